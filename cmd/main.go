@@ -2,10 +2,16 @@ package main
 
 import (
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
 	"Learning-Mode-AI-Ai-Service/pkg/config"
 	"Learning-Mode-AI-Ai-Service/pkg/handlers"
+	"Learning-Mode-AI-Ai-Service/pkg/logging"
+	"Learning-Mode-AI-Ai-Service/pkg/middleware"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
 	"Learning-Mode-AI-Ai-Service/pkg/services"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -17,22 +23,69 @@ func init() {
 		log.Fatal("Error loading .env file")
 	}
 	config.InitConfig()
+	if err := logging.Init(config.LogLevel, config.LogFormat, config.LogPayloads); err != nil {
+		log.Fatalf("Error configuring logging: %v", err)
+	}
+	slog.Info("Configuration loaded", "environment", os.Getenv("ENVIRONMENT"), "redis_host", config.RedisHost)
+	if err := prompts.Init(config.PromptsDir, config.PromptVersion); err != nil {
+		slog.Error("Error loading prompts", "error", err)
+		os.Exit(1)
+	}
+	if err := middleware.InitAuth(); err != nil {
+		slog.Error("Error configuring authentication", "error", err)
+		os.Exit(1)
+	}
 	services.InitRedis()
 }
 
 func main() {
 	// Set up router
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Route not found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.InvalidRequest, "Method not allowed")
+	})
+	r.Use(middleware.RequestID, middleware.Metrics, middleware.LimitBody, middleware.Authenticate, middleware.Identify, middleware.RateLimit, middleware.Budget)
 
 	// Define routes
 	r.HandleFunc("/ai/init-session", handlers.InitializeAssistantSession).Methods("POST")
 	r.HandleFunc("/ai/ask-question", handlers.AskAssistantQuestion).Methods("POST")
+	r.HandleFunc("/ai/submit-understanding", handlers.SubmitUnderstandingHandler).Methods("POST")
 	// New route for video summaries
 	r.HandleFunc("/ai/generate-summary", handlers.GenerateSummaryHandler).Methods("POST")
 	r.HandleFunc("/ai/generate-quiz", handlers.GenerateQuizHandler).Methods("POST")
+	r.HandleFunc("/ai/generate-glossary", handlers.GenerateGlossaryHandler).Methods("POST")
+	r.HandleFunc("/ai/submit-quiz-attempt", handlers.SubmitQuizAttemptHandler).Methods("POST")
+	r.HandleFunc("/ai/index-embeddings", handlers.IndexEmbeddingsHandler).Methods("POST")
+	r.HandleFunc("/ai/related", handlers.RelatedHandler).Methods("POST")
 
+	// Multi-video courses
+	r.HandleFunc("/ai/courses", handlers.CreateCourseHandler).Methods("POST")
+	r.HandleFunc("/ai/courses/{courseID}", handlers.GetCourseHandler).Methods("GET")
+	r.HandleFunc("/ai/courses/{courseID}/summary", handlers.GenerateCourseSummaryHandler).Methods("POST")
+	r.HandleFunc("/ai/courses/{courseID}/quiz", handlers.GenerateCourseQuizHandler).Methods("POST")
+	r.HandleFunc("/ai/courses/{courseID}/init-session", handlers.InitializeCourseSessionHandler).Methods("POST")
+	r.HandleFunc("/ai/courses/{courseID}/ask-question", handlers.AskCourseQuestionHandler).Methods("POST")
+	r.HandleFunc("/ai/transcripts", handlers.IngestTranscriptHandler).Methods("POST")
+	r.HandleFunc("/ai/transcripts/{videoID}", handlers.GetTranscriptHandler).Methods("GET")
+	r.HandleFunc("/ai/transcripts/{videoID}/captions", handlers.GetCaptionsHandler).Methods("GET")
+	r.HandleFunc("/ai/transcripts/{videoID}/cleaned", handlers.GetCleanedTranscriptHandler).Methods("GET")
+	r.HandleFunc("/ai/ingest-frames", handlers.IngestFramesHandler).Methods("POST")
+	r.HandleFunc("/ai/translate-transcript", handlers.TranslateTranscriptHandler).Methods("POST")
+	r.HandleFunc("/ai/usage", handlers.GetUsageReportHandler).Methods("GET")
+	r.HandleFunc("/ai/usage/budget", handlers.GetBudgetHandler).Methods("GET")
+
+	// Prometheus scrapes /metrics outside the authenticated API
+	server := http.NewServeMux()
+	server.Handle("/metrics", middleware.MetricsHandler())
+	server.Handle("/", r)
 
 	// Start the server
-	log.Println("AI Service running on :8082")
-	log.Fatal(http.ListenAndServe(":8082", r))
+	slog.Info("AI Service running", "addr", ":8082")
+	if err := http.ListenAndServe(":8082", server); err != nil {
+		slog.Error("Server stopped", "error", err)
+		os.Exit(1)
+	}
 }
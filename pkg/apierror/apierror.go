@@ -0,0 +1,117 @@
+// Package apierror writes the JSON error responses of the API. Every error response has the shape
+// {"error": {"code": "...", "message": "...", "details": [...]}}, where code is stable, message is for
+// humans and details, on validation errors only, lists the invalid fields.
+package apierror
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Error codes clients can rely on
+const (
+	InvalidRequest      = "invalid_request"
+	PayloadTooLarge     = "payload_too_large"
+	Unauthorized        = "unauthorized"
+	Forbidden           = "forbidden"
+	NotFound            = "not_found"
+	TranscriptNotFound  = "transcript_not_found"
+	SessionNotFound     = "session_not_found"
+	ContentFlagged      = "content_flagged"
+	RateLimited         = "rate_limited"
+	BudgetExceeded      = "budget_exceeded"
+	UpstreamRateLimited = "upstream_rate_limited"
+	UpstreamTimeout     = "upstream_timeout"
+	UpstreamError       = "upstream_error"
+	Internal            = "internal_error"
+)
+
+// FieldError describes what is wrong with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Body is the error object of an error response
+type Body struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+	Reason  string       `json:"reason,omitempty"` // Why content was flagged, for content_flagged only
+}
+
+// Response is the envelope of every error response
+type Response struct {
+	Error Body `json:"error"`
+}
+
+// Write writes an error response
+func Write(w http.ResponseWriter, status int, code, message string) {
+	writeBody(w, status, Body{Code: code, Message: message})
+}
+
+// WriteInvalid writes an invalid_request response listing the invalid fields
+func WriteInvalid(w http.ResponseWriter, details []FieldError) {
+	writeBody(w, http.StatusBadRequest, Body{Code: InvalidRequest, Message: "Request validation failed", Details: details})
+}
+
+func writeBody(w http.ResponseWriter, status int, body Body) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{Error: body})
+}
+
+// WriteError writes the response for an error returned by a service. Known errors get their own
+// code; anything else is reported with the generic message, since service errors can contain
+// upstream response bodies that must not reach clients.
+func WriteError(w http.ResponseWriter, err error, message string) {
+	var apiErr *services.OpenAIError
+	var flagged *services.FlaggedError
+	switch {
+	case errors.Is(err, services.ErrTranscriptNotFound):
+		Write(w, http.StatusNotFound, TranscriptNotFound, "Transcript not found")
+	case errors.Is(err, services.ErrNotIndexed):
+		Write(w, http.StatusNotFound, NotFound, "Video is not indexed, index it with /ai/index-embeddings first")
+	case errors.Is(err, services.ErrSessionNotFound):
+		Write(w, http.StatusNotFound, SessionNotFound, "Session not found, initialize a session first")
+	case errors.Is(err, services.ErrCourseForbidden):
+		Write(w, http.StatusForbidden, Forbidden, "The course belongs to another user")
+	case errors.Is(err, services.ErrUnknownPersona), errors.Is(err, services.ErrInvalidFrame):
+		Write(w, http.StatusBadRequest, InvalidRequest, err.Error())
+	case errors.As(err, &flagged):
+		writeBody(w, http.StatusUnprocessableEntity, Body{
+			Code:    ContentFlagged,
+			Message: "The request was blocked by the content safety checks",
+			Reason:  flagged.Reason,
+		})
+	case errors.Is(err, services.ErrBudgetExceeded):
+		Write(w, http.StatusPaymentRequired, BudgetExceeded, "Monthly usage budget exceeded")
+	case errors.Is(err, context.DeadlineExceeded):
+		Write(w, http.StatusGatewayTimeout, UpstreamTimeout, "The AI provider did not respond in time")
+	case errors.As(err, &apiErr):
+		writeOpenAIError(w, apiErr)
+	default:
+		Write(w, http.StatusInternalServerError, Internal, message)
+	}
+}
+
+// writeOpenAIError reports a failed OpenAI call by its class only
+func writeOpenAIError(w http.ResponseWriter, apiErr *services.OpenAIError) {
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests && apiErr.Retryable():
+		if delay := apiErr.RetryAfter(); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		}
+		Write(w, http.StatusServiceUnavailable, UpstreamRateLimited, "The AI provider is rate limiting requests, try again later")
+	case apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusGatewayTimeout:
+		Write(w, http.StatusGatewayTimeout, UpstreamTimeout, "The AI provider did not respond in time")
+	default:
+		Write(w, http.StatusBadGateway, UpstreamError, "The AI provider failed to process the request")
+	}
+}
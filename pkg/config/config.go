@@ -1,14 +1,51 @@
 package config
 
 import (
-	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 )
 
 var (
-	RedisHost  string
-	TLSEnabled bool
+	RedisHost     string
+	TLSEnabled    bool
+	PromptsDir    string // Optional directory of versioned prompt templates overriding the built-in ones
+	PromptVersion string // Default prompt version; the latest loaded version if empty
+
+	DefaultPersona string            // Persona used when neither the request nor the tenant picks one
+	TenantPersonas map[string]string // Tenant ID -> persona, from TENANT_PERSONAS="tenantA=socratic,tenantB=eli5"
+
+	TutoringMaxHints int // Hints given in tutoring mode before the answer is revealed
+
+	TranscriptCleanup bool // Restore punctuation and sentences in auto-generated transcripts before prompting
+
+	OpenAIMaxRetries int // Retries of a failed OpenAI call after the first attempt
+
+	DefaultPlan string            // Plan of users without a plan:<userId> key in Redis
+	RateLimits  map[string]string // "plan:endpoint" -> "limit/window", from RATE_LIMITS="free:ask-question=20/1m,pro:*=300/1m"
+
+	ModelPrices    map[string]string // Model -> "input/output" USD per 1M tokens, from MODEL_PRICES="gpt-4o-mini=0.15/0.60"
+	MonthlyBudgets map[string]string // Plan -> monthly budget in USD, from MONTHLY_BUDGETS="free=1,pro=20"; plans without one are unlimited
+
+	AuthEnabled       bool   // Require a service signature or user token on /ai/* requests
+	ServiceAuthSecret string // Shared secret other services sign requests with (HMAC-SHA256)
+	JWTSecret         string // Secret of HS256 user tokens
+	JWTPublicKey      string // PEM public key of RS256 or ES256 user tokens
+	JWTIssuer         string // Required iss claim of user tokens, if set
+	JWTAudience       string // Required aud claim of user tokens, if set
+
+	ModerationEnabled bool   // Run learner questions and replies through the OpenAI moderation endpoint
+	ModerationModel   string // Moderation model
+	InjectionGuard    string // What to do with prompt-injection attempts: block (default), log or off
+
+	LogLevel    string // debug, info (default), warn or error
+	LogFormat   string // json (default) or text
+	LogPayloads bool   // Log user content such as questions and answers, for debugging only
+
+	MetricsToken string // Bearer token Prometheus must send to /metrics, if set
+
+	TrustedProxies []netip.Prefix // Proxies whose X-Forwarded-For is honoured, from TRUSTED_PROXIES="10.0.0.0/8,192.168.1.5"
 )
 
 func InitConfig() {
@@ -19,11 +56,71 @@ func InitConfig() {
 		tlsEnabled = false
 	}
 	TLSEnabled = tlsEnabled
+	PromptsDir = os.Getenv("PROMPTS_DIR")
+	PromptVersion = os.Getenv("PROMPT_VERSION")
+	DefaultPersona = os.Getenv("DEFAULT_PERSONA")
+	TenantPersonas = parsePairs(os.Getenv("TENANT_PERSONAS"))
+
+	TutoringMaxHints, err = strconv.Atoi(os.Getenv("TUTORING_MAX_HINTS"))
+	if err != nil || TutoringMaxHints <= 0 {
+		TutoringMaxHints = 3
+	}
+
+	TranscriptCleanup, _ = strconv.ParseBool(os.Getenv("TRANSCRIPT_CLEANUP"))
+
+	OpenAIMaxRetries, err = strconv.Atoi(os.Getenv("OPENAI_MAX_RETRIES"))
+	if err != nil || OpenAIMaxRetries < 0 {
+		OpenAIMaxRetries = 3
+	}
+
+	DefaultPlan = os.Getenv("DEFAULT_PLAN")
+	if DefaultPlan == "" {
+		DefaultPlan = "free"
+	}
+	RateLimits = parsePairs(os.Getenv("RATE_LIMITS"))
+	ModelPrices = parsePairs(os.Getenv("MODEL_PRICES"))
+	MonthlyBudgets = parsePairs(os.Getenv("MONTHLY_BUDGETS"))
+
+	AuthEnabled, err = strconv.ParseBool(os.Getenv("AUTH_ENABLED"))
+	if err != nil {
+		AuthEnabled = true
+	}
+	ServiceAuthSecret = os.Getenv("SERVICE_AUTH_SECRET")
+	JWTSecret = os.Getenv("JWT_SECRET")
+	JWTPublicKey = os.Getenv("JWT_PUBLIC_KEY")
+	JWTIssuer = os.Getenv("JWT_ISSUER")
+	JWTAudience = os.Getenv("JWT_AUDIENCE")
+
+	ModerationEnabled, err = strconv.ParseBool(os.Getenv("MODERATION_ENABLED"))
+	if err != nil {
+		ModerationEnabled = true
+	}
+	ModerationModel = os.Getenv("MODERATION_MODEL")
+	if ModerationModel == "" {
+		ModerationModel = "omni-moderation-latest"
+	}
+	InjectionGuard = strings.ToLower(os.Getenv("INJECTION_GUARD"))
+	if InjectionGuard != "log" && InjectionGuard != "off" {
+		InjectionGuard = "block"
+	}
+
+	LogLevel = os.Getenv("LOG_LEVEL")
+	if LogLevel == "" {
+		LogLevel = "info"
+	}
+	LogFormat = os.Getenv("LOG_FORMAT")
+	if LogFormat == "" {
+		LogFormat = "json"
+	}
+	LogPayloads, _ = strconv.ParseBool(os.Getenv("LOG_PAYLOADS"))
+
+	MetricsToken = os.Getenv("METRICS_TOKEN")
+
+	TrustedProxies = parsePrefixes(os.Getenv("TRUSTED_PROXIES"))
 
 	if env == "local" {
 		RedisHost = "localhost:6379"
 		TLSEnabled = false
-		fmt.Println("Running in local mode")
 	} else {
 		redisEnvHost := os.Getenv("REDIS_HOST")
 		if redisEnvHost != "" {
@@ -31,6 +128,32 @@ func InitConfig() {
 		} else {
 			RedisHost = "redis:6379"
 		}
-		fmt.Println("Running in Docker mode")
 	}
 }
+
+// parsePairs parses "key=value,key2=value2" into a map, skipping malformed entries
+func parsePairs(s string) map[string]string {
+	pairs := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || key == "" {
+			continue
+		}
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return pairs
+}
+
+// parsePrefixes parses a comma-separated list of CIDRs and single addresses, skipping malformed entries
+func parsePrefixes(s string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return prefixes
+}
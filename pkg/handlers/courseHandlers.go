@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateCourseRequest struct {
+	CourseID string   `json:"course_id,omitempty"` // Generated if empty
+	Title    string   `json:"title"`
+	VideoIDs []string `json:"video_ids"`
+	Identity
+}
+
+type CourseGenerationRequest struct {
+	Language      string `json:"language,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+	Identity
+}
+
+type CourseSessionRequest struct {
+	Persona       string `json:"persona,omitempty"`
+	TenantID      string `json:"tenant_id,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+	Identity
+}
+
+type CourseQuestionRequest struct {
+	VideoID   string `json:"video_id,omitempty"` // Video the learner is currently watching, if any
+	Question  string `json:"question"`
+	Timestamp int    `json:"timestamp"`
+	Language  string `json:"language,omitempty"`
+	Persona   string `json:"persona,omitempty"`
+	Identity
+}
+
+// loadCourse fetches the course named in the URL, writing the error response if it cannot
+func loadCourse(w http.ResponseWriter, r *http.Request) *services.Course {
+	courseID := mux.Vars(r)["courseID"]
+	if !courseIDPattern.MatchString(courseID) {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Course not found")
+		return nil
+	}
+	course, err := services.GetCourse(r.Context(), courseID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve course", "course_id", courseID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve course")
+		return nil
+	}
+	if course == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Course not found")
+		return nil
+	}
+	return course
+}
+
+// CreateCourseHandler stores an ordered list of videos as a course. An existing course can only be
+// replaced by the user who created it.
+func CreateCourseHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateCourseRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.check(len(req.VideoIDs) > 0, "video_ids", "is required")
+	v.check(len(req.VideoIDs) <= maxCourseVideos, "video_ids", fmt.Sprintf("must list at most %d videos", maxCourseVideos))
+	for i, videoID := range req.VideoIDs {
+		v.videoID(fmt.Sprintf("video_ids[%d]", i), videoID, true)
+	}
+	v.maxLength("title", req.Title, maxTitleLength)
+	v.check(req.CourseID == "" || courseIDPattern.MatchString(req.CourseID), "course_id", "must be 1 to 64 letters, digits, - or _")
+	if !v.valid(w) {
+		return
+	}
+
+	course := &services.Course{ID: req.CourseID, Title: req.Title, VideoIDs: req.VideoIDs}
+	if err := services.SaveCourse(r.Context(), course); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to save course", "error", err)
+		apierror.WriteError(w, err, "Failed to save course")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(course)
+}
+
+// GetCourseHandler returns a course
+func GetCourseHandler(w http.ResponseWriter, r *http.Request) {
+	course := loadCourse(w, r)
+	if course == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(course)
+}
+
+// GenerateCourseSummaryHandler summarizes all videos of a course
+func GenerateCourseSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	course := loadCourse(w, r)
+	if course == nil {
+		return
+	}
+
+	// The body is optional for course generation requests
+	var req CourseGenerationRequest
+	if !decodeJSON(w, r, &req, true) {
+		return
+	}
+
+	var v validator
+	v.language("language", req.Language)
+	if !v.valid(w) {
+		return
+	}
+
+	promptVersion := prompts.ResolveVersion(req.PromptVersion)
+	summary, language, err := services.GenerateCourseSummary(r.Context(), course, req.Language, promptVersion)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to generate course summary", "course_id", course.ID, "error", err)
+		apierror.WriteError(w, err, "Failed to generate course summary")
+		return
+	}
+
+	resp := SummaryResponse{Summary: summary.Summary, Language: language, PromptVersion: summary.PromptVersion}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GenerateCourseQuizHandler generates a cumulative quiz across all videos of a course
+func GenerateCourseQuizHandler(w http.ResponseWriter, r *http.Request) {
+	course := loadCourse(w, r)
+	if course == nil {
+		return
+	}
+
+	// The body is optional for course generation requests
+	var req CourseGenerationRequest
+	if !decodeJSON(w, r, &req, true) {
+		return
+	}
+
+	var v validator
+	v.language("language", req.Language)
+	if !v.valid(w) {
+		return
+	}
+
+	promptVersion := prompts.ResolveVersion(req.PromptVersion)
+	quiz, err := services.GenerateCourseQuiz(r.Context(), course, req.Language, promptVersion)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to generate course quiz", "course_id", course.ID, "error", err)
+		apierror.WriteError(w, err, "Failed to generate course quiz")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Prompt-Version", promptVersion)
+	json.NewEncoder(w).Encode(quiz)
+}
+
+// InitializeCourseSessionHandler creates an assistant session spanning every transcript of the course
+func InitializeCourseSessionHandler(w http.ResponseWriter, r *http.Request) {
+	course := loadCourse(w, r)
+	if course == nil {
+		return
+	}
+
+	var req CourseSessionRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	caller := services.CallerFromContext(r.Context())
+	var v validator
+	v.required("userId", caller.UserID)
+	if !v.valid(w) {
+		return
+	}
+	// An authenticated tenant cannot pick another tenant's persona
+	if caller.TenantID != "" {
+		req.TenantID = caller.TenantID
+	}
+
+	persona, err := services.ResolvePersona(req.Persona, req.TenantID)
+	if err != nil {
+		apierror.WriteError(w, err, "Invalid request")
+		return
+	}
+
+	assistantID, err := services.CreateCourseAssistant(r.Context(), course, caller.UserID, persona, prompts.ResolveVersion(req.PromptVersion))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create course assistant", "course_id", course.ID, "error", err)
+		apierror.WriteError(w, err, "Failed to initialize course session")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":      "Course session initialized successfully.",
+		"assistant_id": assistantID,
+	})
+}
+
+// AskCourseQuestionHandler asks the course assistant a question
+func AskCourseQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	course := loadCourse(w, r)
+	if course == nil {
+		return
+	}
+
+	var req CourseQuestionRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.text("question", req.Question, maxQuestionLength)
+	v.videoID("video_id", req.VideoID, false)
+	v.timestamp(ctx, "timestamp", req.VideoID, req.Timestamp)
+	v.language("language", req.Language)
+	v.persona("persona", req.Persona)
+	if !v.valid(w) {
+		return
+	}
+	if err := services.ScreenUserInput(ctx, "question", req.Question); err != nil {
+		apierror.WriteError(w, err, "Failed to check question")
+		return
+	}
+
+	persona, err := services.NormalizePersona(req.Persona)
+	if err != nil {
+		apierror.WriteError(w, err, "Invalid request")
+		return
+	}
+
+	assistantID, err := services.GetCourseAssistantID(ctx, services.CallerFromContext(ctx).UserID, course.ID)
+	if err != nil {
+		apierror.WriteError(w, err, "Failed to load session")
+		return
+	}
+
+	promptVersion := prompts.ResolveVersion(services.GetAssistantPromptVersion(ctx, assistantID))
+	response, err := services.AskAssistantQuestion(ctx, req.VideoID, assistantID, req.Question, req.Timestamp, services.QuestionOptions{
+		Language:      services.NormalizeLanguage(req.Language),
+		PromptVersion: promptVersion,
+		Persona:       persona,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to ask course assistant", "course_id", course.ID, "error", err)
+		apierror.WriteError(w, err, "Failed to get answer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AskAssistantResponse{Answer: response})
+}
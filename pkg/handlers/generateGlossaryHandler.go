@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type GlossaryRequest struct {
+	VideoID       string `json:"video_id"`
+	Language      string `json:"language,omitempty"`       // Optional ISO 639-1 code, detected from the transcript if empty
+	PromptVersion string `json:"prompt_version,omitempty"` // Optional prompt version, the environment default if empty
+	Identity
+}
+
+// GenerateGlossaryHandler returns the key terms of a video with definitions, cached per video and language
+func GenerateGlossaryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req GlossaryRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.language("language", req.Language)
+	if !v.valid(w) {
+		return
+	}
+
+	promptVersion := prompts.ResolveVersion(req.PromptVersion)
+
+	transcript, language, err := services.PrepareTranscript(ctx, req.VideoID, req.Language)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve transcript", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve transcript")
+		return
+	}
+	if transcript == "" {
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
+		return
+	}
+
+	glossary, err := services.GetGlossaryFromRedis(ctx, req.VideoID, language)
+	if err != nil {
+		apierror.WriteError(w, err, "Error checking cache")
+		return
+	}
+
+	hit := glossary != nil && glossary.PromptVersion == promptVersion
+	metrics.CacheLookup("glossary", hit)
+	if !hit {
+		glossary, err = services.GenerateGlossary(ctx, transcript, language, promptVersion)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate glossary", "video_id", req.VideoID, "error", err)
+			apierror.WriteError(w, err, "Failed to generate glossary")
+			return
+		}
+
+		if err := services.StoreGlossaryInRedis(ctx, req.VideoID, glossary); err != nil {
+			slog.WarnContext(ctx, "Failed to cache glossary", "video_id", req.VideoID, "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(glossary)
+}
@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
 	"encoding/json"
+	"log/slog"
 	"net/http"
-	"Learning-Mode-AI-Ai-Service/pkg/services"
-	"log"
 )
 
 type QuizRequest struct {
-	VideoID string `json:"video_id"` // Accept only the video_id
+	VideoID       string `json:"video_id"`
+	Language      string `json:"language,omitempty"`       // Optional ISO 639-1 code, detected from the transcript if empty
+	PromptVersion string `json:"prompt_version,omitempty"` // Optional prompt version, the environment default if empty
+	Adaptive      bool   `json:"adaptive,omitempty"`       // Target the user's weak topics based on past attempts
+	Identity
 }
 
 type QuizResponse struct {
@@ -16,37 +22,59 @@ type QuizResponse struct {
 }
 
 func GenerateQuizHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req QuizRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req, false) {
 		return
 	}
 
-	log.Printf("Request received with video ID: %s", req.VideoID)
+	slog.DebugContext(ctx, "Generating quiz", "video_id", req.VideoID)
+
+	userID := services.CallerFromContext(ctx).UserID
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.language("language", req.Language)
+	v.check(!req.Adaptive || userID != "", "userId", "is required for adaptive quizzes")
+	if !v.valid(w) {
+		return
+	}
 
-	transcript, err := services.GetTranscriptFromRedis(req.VideoID)
+	transcript, language, err := services.PrepareTranscript(ctx, req.VideoID, req.Language)
 	if err != nil {
-		log.Printf("Error retrieving transcript from Redis: %v", err)
-		http.Error(w, "Failed to retrieve transcript", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Failed to retrieve transcript", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve transcript")
 		return
 	}
 
 	if transcript == "" {
-		log.Printf("Transcript not found in Redis for video ID: %s", req.VideoID)
-		http.Error(w, "Transcript not found", http.StatusNotFound)
+		slog.WarnContext(ctx, "Transcript not found", "video_id", req.VideoID)
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
 		return
 	}
 
-	quiz, err := services.GenerateQuiz(transcript)
+	promptVersion := prompts.ResolveVersion(req.PromptVersion)
+	var quiz map[string]interface{}
+	if req.Adaptive {
+		mastery, err := services.GetMastery(ctx, userID, req.VideoID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to retrieve mastery", "video_id", req.VideoID, "error", err)
+			apierror.WriteError(w, err, "Failed to retrieve mastery")
+			return
+		}
+		quiz, err = services.GenerateAdaptiveQuiz(ctx, transcript, language, promptVersion, mastery)
+	} else {
+		quiz, err = services.GenerateQuiz(ctx, transcript, language, promptVersion)
+	}
 	if err != nil {
-		log.Printf("Error generating quiz: %v", err)
-		http.Error(w, "Failed to generate quiz", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Failed to generate quiz", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to generate quiz")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Prompt-Version", promptVersion)
 	if err := json.NewEncoder(w).Encode(quiz); err != nil {
-		log.Printf("Error encoding response: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Failed to encode response", "error", err)
+		apierror.WriteError(w, err, "Failed to encode response")
 	}
 }
@@ -1,57 +1,96 @@
 package handlers
 
 import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
 	"encoding/json"
 	"net/http"
-	"Learning-Mode-AI-Ai-Service/pkg/services"
 )
 
 type SummaryRequest struct {
-	VideoID string `json:"video_id"` // Accept only the video_id
+	VideoID       string `json:"video_id"`
+	Language      string `json:"language,omitempty"`       // Optional ISO 639-1 code, detected from the transcript if empty
+	PromptVersion string `json:"prompt_version,omitempty"` // Optional prompt version, the environment default if empty
+	Identity
 }
 
 type SummaryResponse struct {
-	Summary string `json:"summary"`
+	Summary       string `json:"summary"`
+	Language      string `json:"language"`
+	PromptVersion string `json:"prompt_version"`
 }
 
 func GenerateSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req SummaryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req, false) {
 		return
 	}
 
-	// Check Redis for existing summary
-	summary, err := services.GetSummaryFromRedis(req.VideoID)
-	if err != nil {
-		http.Error(w, "Error checking cache", http.StatusInternalServerError)
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.language("language", req.Language)
+	if !v.valid(w) {
 		return
 	}
 
-	if summary != "" {
-		resp := SummaryResponse{Summary: summary}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-		return
+	promptVersion := prompts.ResolveVersion(req.PromptVersion)
+
+	// Check Redis for an existing summary in the requested language
+	language := services.NormalizeLanguage(req.Language)
+	if language != "" {
+		cached, err := services.GetSummaryFromRedis(ctx, req.VideoID, language)
+		if err != nil {
+			apierror.WriteError(w, err, "Error checking cache")
+			return
+		}
+
+		if cached != nil && cached.PromptVersion == promptVersion {
+			metrics.CacheLookup("summary", true)
+			resp := SummaryResponse{Summary: cached.Summary, Language: language, PromptVersion: cached.PromptVersion}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
 	}
 
-	// If not cached, generate a new summary
-	transcript, err := services.GetTranscriptFromRedis(req.VideoID)
+	// Load the transcript in the output language
+	transcript, language, err := services.PrepareTranscript(ctx, req.VideoID, language)
+	if err != nil {
+		apierror.WriteError(w, err, "Failed to retrieve transcript")
+		return
+	}
 	if transcript == "" {
-		http.Error(w, "Transcript not found", http.StatusNotFound)
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
 		return
 	}
 
-	summary, err = services.GenerateSummary(transcript)
+	// The language may have just been detected, so check the cache again
+	cached, err := services.GetSummaryFromRedis(ctx, req.VideoID, language)
 	if err != nil {
-		http.Error(w, "Failed to generate summary", http.StatusInternalServerError)
+		apierror.WriteError(w, err, "Error checking cache")
 		return
 	}
 
-	// Cache the new summary in Redis
-	services.StoreSummaryInRedis(req.VideoID, summary)
+	var summary string
+	hit := cached != nil && cached.PromptVersion == promptVersion
+	metrics.CacheLookup("summary", hit)
+	if hit {
+		summary = cached.Summary
+	} else {
+		summary, err = services.GenerateSummary(ctx, transcript, language, promptVersion)
+		if err != nil {
+			apierror.WriteError(w, err, "Failed to generate summary")
+			return
+		}
 
-	resp := SummaryResponse{Summary: summary}
+		// Cache the new summary in Redis along with the prompt version that produced it
+		services.StoreSummaryInRedis(ctx, req.VideoID, language, summary, promptVersion)
+	}
+
+	resp := SummaryResponse{Summary: summary, Language: language, PromptVersion: promptVersion}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-}
\ No newline at end of file
+}
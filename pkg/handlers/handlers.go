@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
 	"Learning-Mode-AI-Ai-Service/pkg/services"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 )
 
@@ -16,27 +19,75 @@ type AskAssistantQuestionRequest struct {
 	Question    string `json:"question"`
 }
 
+// InitializeSessionRequest is an init-session request, for the user the caller is authenticated as
+type InitializeSessionRequest struct {
+	services.InitializeRequest
+	Identity
+}
+
+// AskQuestionRequest is an ask-question request
+type AskQuestionRequest struct {
+	VideoID       string `json:"video_id"`
+	Question      string `json:"question"`
+	Timestamp     int    `json:"timestamp"`
+	Frame         string `json:"frame,omitempty"`     // Base64-encoded frame at the current timestamp
+	FrameKey      string `json:"frame_key,omitempty"` // frame: key written by the capture service
+	Language      string `json:"language,omitempty"`  // Optional ISO 639-1 code for the answer
+	PromptVersion string `json:"prompt_version,omitempty"`
+	Persona       string `json:"persona,omitempty"` // Optional persona for this answer only
+	Mode          string `json:"mode,omitempty"`    // "tutoring" for hints instead of direct answers
+	NewQuestion   bool   `json:"new_question,omitempty"`
+	RevealAnswer  bool   `json:"reveal_answer,omitempty"`
+	// Follow the answer with a check-for-understanding question
+	CheckUnderstanding bool `json:"check_understanding,omitempty"`
+	Identity
+}
+
 type AskAssistantResponse struct {
-	Answer string `json:"answer"`
-	Error  string `json:"error,omitempty"`
+	Answer string                       `json:"answer"`
+	Check  *services.UnderstandingCheck `json:"check,omitempty"`
+	Error  string                       `json:"error,omitempty"`
 }
 
 // InitializeAssistantSession: Create a new assistant based on YouTube video metadata and return the assistant ID.
 func InitializeAssistantSession(w http.ResponseWriter, r *http.Request) {
 	// Decode the incoming request
-	var initReq services.InitializeRequest
-	if err := json.NewDecoder(r.Body).Decode(&initReq); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var req InitializeSessionRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	initReq := req.InitializeRequest
+
+	var v validator
+	v.videoID("video_id", initReq.VideoID, true)
+	v.maxLength("title", initReq.Title, maxTitleLength)
+	v.maxLength("channel", initReq.Channel, maxTitleLength)
+	v.persona("persona", initReq.Persona)
+	if !v.valid(w) {
 		return
 	}
 
+	// An authenticated tenant cannot pick another tenant's persona
+	caller := services.CallerFromContext(r.Context())
+	if caller.TenantID != "" {
+		initReq.TenantID = caller.TenantID
+	}
+
 	// Create an assistant with metadata
-	assistantID, err := services.CreateAssistantWithMetadata(initReq)
+	assistantID, err := services.CreateAssistantWithMetadata(r.Context(), initReq)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to create assistant", "video_id", initReq.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to initialize session")
 		return
 	}
 
+	// Remember the session so questions from this user in this tenant find it
+	if caller.UserID != "" {
+		if err := services.StoreAssistantID(r.Context(), caller.UserID, initReq.VideoID, assistantID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to store assistant ID", "assistant_id", assistantID, "error", err)
+		}
+	}
+
 	response := map[string]string{
 		"message":      "Assistant session initialized successfully.",
 		"assistant_id": assistantID,
@@ -44,40 +95,109 @@ func InitializeAssistantSession(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-	log.Printf("Assistant session initialized with ID: %s for video '%s'", assistantID, initReq.VideoID)
+	slog.InfoContext(r.Context(), "Assistant session initialized", "assistant_id", assistantID, "video_id", initReq.VideoID)
 }
 
 // Handler for asking a question to the assistant
 func AskAssistantQuestion(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		VideoID   string `json:"video_id"`
-		UserID    string `json:"userId"`
-		Question  string `json:"question"`
-		Timestamp int    `json:"timestamp"`
+	ctx := r.Context()
+	var req AskQuestionRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	userID := services.CallerFromContext(ctx).UserID
+	var v validator
+	v.check(userID != "", "userId", "is required")
+	v.videoID("video_id", req.VideoID, true)
+	v.text("question", req.Question, maxQuestionLength)
+	v.timestamp(ctx, "timestamp", req.VideoID, req.Timestamp)
+	v.language("language", req.Language)
+	v.persona("persona", req.Persona)
+	v.check(req.Mode == "" || req.Mode == "tutoring", "mode", "must be tutoring or empty")
+	v.check(req.FrameKey == "" || services.ValidFrameKey(req.FrameKey), "frame_key", "must be a frame: key written by the capture service")
+	if !v.valid(w) {
+		return
+	}
+	persona, _ := services.NormalizePersona(req.Persona)
+	if err := services.ScreenUserInput(ctx, "question", req.Question); err != nil {
+		apierror.WriteError(w, err, "Failed to check question")
+		return
 	}
 
-	// Parse the request body
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	slog.DebugContext(ctx, "Looking up assistant", "video_id", req.VideoID)
+	assistantID, err := services.GetAssistantIDFromRedis(ctx, userID, req.VideoID)
+	if err != nil {
+		apierror.WriteError(w, err, "Failed to load session")
 		return
 	}
+	slog.DebugContext(ctx, "Found assistant", "assistant_id", assistantID)
 
-	log.Printf("🔍 Looking up AssistantID for UserID: %s and VideoID: %s", req.UserID, req.VideoID)
-	assistantID, err := services.GetAssistantIDFromRedis(req.UserID, req.VideoID)
+	// Resolve the optional video frame for visual questions
+	frame, err := services.LoadFrame(ctx, req.Frame, req.FrameKey)
 	if err != nil {
-		http.Error(w, "Assistant session not found for this user and video", http.StatusBadRequest)
+		slog.WarnContext(ctx, "Failed to load frame", "video_id", req.VideoID, "error", err)
+		if errors.Is(err, services.ErrInvalidFrame) {
+			apierror.WriteError(w, err, "Invalid video frame")
+			return
+		}
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid or missing video frame")
+		return
+	}
+
+	// Default to the prompt version the assistant was created with
+	promptVersion := req.PromptVersion
+	if promptVersion == "" {
+		promptVersion = services.GetAssistantPromptVersion(ctx, assistantID)
+	}
+	promptVersion = prompts.ResolveVersion(promptVersion)
+
+	opts := services.QuestionOptions{
+		Frame:         frame,
+		Language:      services.NormalizeLanguage(req.Language),
+		PromptVersion: promptVersion,
+		Persona:       persona,
+	}
+
+	// In tutoring mode the learner gets hints, tracked per question, until the answer is revealed
+	if req.Mode == "tutoring" {
+		result, err := services.AskTutoringQuestion(ctx, req.VideoID, assistantID, req.Question, req.Timestamp, opts, services.TutoringOptions{
+			NewQuestion:  req.NewQuestion,
+			RevealAnswer: req.RevealAnswer,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to ask tutoring question", "assistant_id", assistantID, "error", err)
+			apierror.WriteError(w, err, "Failed to get answer")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Prompt-Version", promptVersion)
+		json.NewEncoder(w).Encode(result)
 		return
 	}
-	log.Printf("✅ Found AssistantID: %s", assistantID)
 
 	// Pass the timestamp to the service
-	response, err := services.AskAssistantQuestion(req.VideoID, assistantID, req.Question, req.Timestamp)
+	response, err := services.AskAssistantQuestion(ctx, req.VideoID, assistantID, req.Question, req.Timestamp, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Failed to ask assistant", "assistant_id", assistantID, "error", err)
+		apierror.WriteError(w, err, "Failed to get answer")
 		return
 	}
 
+	resp := AskAssistantResponse{Answer: response}
+	if req.CheckUnderstanding {
+		// A failed check should not cost the learner their answer
+		check, err := services.GenerateUnderstandingCheck(ctx, req.VideoID, assistantID, req.Question, response, req.Timestamp, opts)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to generate understanding check", "assistant_id", assistantID, "error", err)
+		} else {
+			resp.Check = check
+		}
+	}
+
 	// Return the assistant's response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"answer": response})
+	w.Header().Set("X-Prompt-Version", promptVersion)
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type IngestFramesRequest struct {
+	VideoID string                      `json:"video_id"`
+	Frames  []services.TimestampedFrame `json:"frames"`
+	Identity
+}
+
+type IngestFramesResponse struct {
+	Ingested int                      `json:"ingested"`
+	Segments []services.VisualSegment `json:"segments"`
+	Failed   []services.FrameFailure  `json:"failed,omitempty"` // Frames skipped because their extraction failed
+}
+
+// IngestFramesHandler receives timestamped frames and merges their on-screen text into the visual transcript.
+// The visual transcript is shared by every tenant, so only services may ingest frames.
+func IngestFramesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireService(w, r, "Frames can only be ingested by services") {
+		return
+	}
+
+	var req IngestFramesRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.check(len(req.Frames) > 0, "frames", "is required")
+	for i, frame := range req.Frames {
+		v.check(frame.Timestamp >= 0, fmt.Sprintf("frames[%d].timestamp", i), "must not be negative")
+		field := fmt.Sprintf("frames[%d].frame", i)
+		if strings.TrimSpace(frame.Frame) == "" {
+			v.add(field, "is required")
+		} else if err := services.CheckEncodedFrame(frame.Frame); err != nil {
+			v.add(field, err.Error())
+		}
+	}
+	if !v.valid(w) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Ingesting frames", "video_id", req.VideoID, "frames", len(req.Frames))
+
+	segments, failed, err := services.IngestFrames(r.Context(), req.VideoID, req.Frames)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to ingest frames", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to ingest frames")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IngestFramesResponse{Ingested: len(segments), Segments: segments, Failed: failed})
+}
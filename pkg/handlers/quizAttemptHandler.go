@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type QuizAttemptRequest struct {
+	VideoID string                `json:"video_id"`
+	Answers []services.QuizAnswer `json:"answers"`
+	Identity
+}
+
+type QuizAttemptResponse struct {
+	Mastery map[string]float64 `json:"mastery"`
+}
+
+// SubmitQuizAttemptHandler stores a learner's quiz results and returns their updated mastery per topic
+func SubmitQuizAttemptHandler(w http.ResponseWriter, r *http.Request) {
+	var req QuizAttemptRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	userID := services.CallerFromContext(r.Context()).UserID
+	var v validator
+	v.check(userID != "", "userId", "is required")
+	v.videoID("video_id", req.VideoID, true)
+	v.check(len(req.Answers) > 0, "answers", "is required")
+	v.check(len(req.Answers) <= maxQuizAnswers, "answers", fmt.Sprintf("must have at most %d answers", maxQuizAnswers))
+	for i, answer := range req.Answers {
+		field := fmt.Sprintf("answers[%d]", i)
+		v.required(field+".topic", answer.Topic)
+		v.maxLength(field+".topic", answer.Topic, services.MaxTopicLength)
+		v.check(answer.Difficulty == "" || slices.Contains(services.QuizDifficulties, answer.Difficulty),
+			field+".difficulty", "must be one of "+strings.Join(services.QuizDifficulties, ", "))
+	}
+	if !v.valid(w) {
+		return
+	}
+
+	mastery, err := services.RecordQuizAttempt(r.Context(), userID, req.VideoID, req.Answers)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record quiz attempt", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to record quiz attempt")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QuizAttemptResponse{Mastery: mastery})
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+const defaultRelatedLimit = 5
+
+type IndexEmbeddingsRequest struct {
+	VideoID string `json:"video_id"`
+	Identity
+}
+
+type RelatedRequest struct {
+	VideoID   string `json:"video_id"`
+	Timestamp int    `json:"timestamp,omitempty"`
+	Question  string `json:"question,omitempty"` // Searched instead of the transcript around the timestamp when set
+	Limit     int    `json:"limit,omitempty"`
+	Identity
+}
+
+type RelatedResponse struct {
+	Related []services.RelatedSegment `json:"related"`
+}
+
+// IndexEmbeddingsHandler embeds a video's transcript so it can show up in related-video searches.
+// The index is shared by every tenant, so only services may write to it.
+func IndexEmbeddingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireService(w, r, "Embeddings can only be indexed by services") {
+		return
+	}
+
+	var req IndexEmbeddingsRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	if !v.valid(w) {
+		return
+	}
+
+	chunks, err := services.IndexTranscriptEmbeddings(r.Context(), req.VideoID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to index embeddings", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to index transcript")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"video_id": req.VideoID, "chunks": chunks})
+}
+
+// RelatedHandler returns other videos and timestamps that discuss the same concept
+func RelatedHandler(w http.ResponseWriter, r *http.Request) {
+	var req RelatedRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.check(req.VideoID != "" || req.Question != "", "video_id", "video_id or question is required")
+	v.videoID("video_id", req.VideoID, false)
+	v.maxLength("question", req.Question, maxQuestionLength)
+	v.timestamp(r.Context(), "timestamp", req.VideoID, req.Timestamp)
+	v.check(req.Limit >= 0 && req.Limit <= maxRelatedLimit, "limit", fmt.Sprintf("must be between 0 and %d", maxRelatedLimit))
+	if !v.valid(w) {
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultRelatedLimit
+	}
+
+	related, err := services.FindRelatedSegments(r.Context(), req.VideoID, req.Timestamp, req.Question, req.Limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to find related segments", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to find related videos")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RelatedResponse{Related: related})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type IngestTranscriptRequest struct {
+	VideoID       string                       `json:"video_id"`
+	Language      string                       `json:"language,omitempty"`
+	Source        string                       `json:"source,omitempty"`
+	AutoGenerated bool                         `json:"auto_generated"`
+	Format        string                       `json:"format,omitempty"`   // segments (default), vtt or srt
+	Segments      []services.TranscriptSegment `json:"segments,omitempty"` // For the segments format
+	Content       string                       `json:"content,omitempty"`  // WebVTT or SRT file content
+	Identity
+}
+
+// IngestTranscriptHandler normalizes and stores a transcript sent as segments, WebVTT or SRT.
+// Transcripts are shared by every tenant, so only services may ingest them.
+func IngestTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireService(w, r, "Transcripts can only be ingested by services") {
+		return
+	}
+
+	var req IngestTranscriptRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.language("language", req.Language)
+	switch req.Format {
+	case "", services.FormatSegments:
+	case services.FormatWebVTT, services.FormatSRT:
+		v.required("content", req.Content)
+	default:
+		v.add("format", "must be one of segments, vtt, srt")
+	}
+	if !v.valid(w) {
+		return
+	}
+
+	segments := req.Segments
+	switch req.Format {
+	case "", services.FormatSegments:
+	case services.FormatWebVTT, services.FormatSRT:
+		var err error
+		segments, err = services.ParseCaptions(req.Content)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid caption content: "+err.Error())
+			return
+		}
+	}
+
+	if len(segments) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Transcript has no segments")
+		return
+	}
+
+	transcript := &services.StoredTranscript{
+		VideoID:       req.VideoID,
+		Language:      req.Language,
+		Source:        req.Source,
+		AutoGenerated: req.AutoGenerated,
+		Segments:      segments,
+	}
+	if err := services.IngestTranscript(r.Context(), transcript); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to ingest transcript", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to ingest transcript")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transcript)
+}
+
+// GetTranscriptHandler returns the normalized transcript of a video
+func GetTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	videoID := mux.Vars(r)["videoID"]
+	transcript, err := services.GetStoredTranscript(r.Context(), videoID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve transcript", "video_id", videoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve transcript")
+		return
+	}
+	if transcript == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transcript)
+}
+
+// GetCaptionsHandler renders the transcript of a video, or its translation, as WebVTT or SRT captions
+func GetCaptionsHandler(w http.ResponseWriter, r *http.Request) {
+	videoID := mux.Vars(r)["videoID"]
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = services.FormatWebVTT
+	}
+	// The language reaches the translation prompt and cache keys, so only language codes are accepted
+	var v validator
+	v.videoID("videoID", videoID, true)
+	v.language("language", query.Get("language"))
+	v.check(format == services.FormatWebVTT || format == services.FormatSRT, "format", "must be vtt or srt")
+	if !v.valid(w) {
+		return
+	}
+
+	cleaned, _ := strconv.ParseBool(query.Get("cleaned"))
+	segments, language, err := services.GetCaptionSegments(r.Context(), videoID, query.Get("language"), cleaned)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve captions", "video_id", videoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve captions")
+		return
+	}
+	if segments == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
+		return
+	}
+
+	cues := services.BuildCues(segments)
+	w.Header().Set("Content-Language", language)
+	if format == services.FormatSRT {
+		w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+		w.Write([]byte(services.RenderSRT(cues)))
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Write([]byte(services.RenderWebVTT(cues)))
+}
+
+// GetCleanedTranscriptHandler returns the punctuated sentences of a video's transcript with their
+// original timestamps. Cleaning takes minutes, so a transcript that has not been cleaned yet gets a
+// 202 while the cleanup runs in the background.
+func GetCleanedTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	videoID := mux.Vars(r)["videoID"]
+	cleaned, err := services.GetCleanedTranscript(r.Context(), videoID)
+	if errors.Is(err, services.ErrCleanupUnavailable) {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Transcript is not cleaned up")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve cleaned transcript", "video_id", videoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve cleaned transcript")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if cleaned == nil {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "cleaning"})
+		return
+	}
+	json.NewEncoder(w).Encode(cleaned)
+}
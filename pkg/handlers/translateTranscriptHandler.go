@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type TranslateTranscriptRequest struct {
+	VideoID  string `json:"video_id"`
+	Language string `json:"language"` // Target ISO 639-1 code
+	Identity
+}
+
+type TranslateTranscriptResponse struct {
+	VideoID        string `json:"video_id"`
+	Language       string `json:"language"`
+	SourceLanguage string `json:"source_language"`
+	Transcript     string `json:"transcript"`
+}
+
+// TranslateTranscriptHandler translates the stored transcript into the target language, preserving timestamps
+func TranslateTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req TranslateTranscriptRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	var v validator
+	v.videoID("video_id", req.VideoID, true)
+	v.required("language", req.Language)
+	v.language("language", req.Language)
+	if !v.valid(w) {
+		return
+	}
+	language := services.NormalizeLanguage(req.Language)
+
+	transcript, err := services.GetSourceTranscript(ctx, req.VideoID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve transcript", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve transcript")
+		return
+	}
+	if transcript == "" {
+		apierror.Write(w, http.StatusNotFound, apierror.TranscriptNotFound, "Transcript not found")
+		return
+	}
+
+	sourceLanguage, err := services.GetTranscriptLanguage(ctx, req.VideoID, transcript)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to detect transcript language", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to detect transcript language")
+		return
+	}
+
+	translated, err := services.GetTranslatedTranscript(ctx, req.VideoID, transcript, language)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to translate transcript", "video_id", req.VideoID, "error", err)
+		apierror.WriteError(w, err, "Failed to translate transcript")
+		return
+	}
+
+	resp := TranslateTranscriptResponse{
+		VideoID:        req.VideoID,
+		Language:       language,
+		SourceLanguage: sourceLanguage,
+		Transcript:     translated,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
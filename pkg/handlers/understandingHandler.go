@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+type SubmitUnderstandingRequest struct {
+	VideoID       string `json:"video_id"`
+	CheckID       string `json:"check_id"`
+	Reply         string `json:"reply"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+	Identity
+}
+
+// SubmitUnderstandingHandler evaluates the learner's reply to a check-for-understanding question
+func SubmitUnderstandingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req SubmitUnderstandingRequest
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+
+	userID := services.CallerFromContext(ctx).UserID
+	var v validator
+	v.check(userID != "", "userId", "is required")
+	v.videoID("video_id", req.VideoID, true)
+	v.required("check_id", req.CheckID)
+	v.text("reply", req.Reply, maxReplyLength)
+	if !v.valid(w) {
+		return
+	}
+	if err := services.ScreenUserInput(ctx, "reply", req.Reply); err != nil {
+		apierror.WriteError(w, err, "Failed to check reply")
+		return
+	}
+
+	assistantID, err := services.GetAssistantIDFromRedis(ctx, userID, req.VideoID)
+	if err != nil {
+		apierror.WriteError(w, err, "Failed to load session")
+		return
+	}
+
+	promptVersion := req.PromptVersion
+	if promptVersion == "" {
+		promptVersion = services.GetAssistantPromptVersion(ctx, assistantID)
+	}
+	promptVersion = prompts.ResolveVersion(promptVersion)
+
+	evaluation, err := services.EvaluateUnderstanding(ctx, assistantID, req.CheckID, req.Reply, promptVersion)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to evaluate understanding check", "check_id", req.CheckID, "error", err)
+		apierror.WriteError(w, err, "Failed to evaluate reply")
+		return
+	}
+	if evaluation == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.NotFound, "Understanding check not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(evaluation)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Longest date range a usage report may cover, daily aggregates are kept for 90 days
+const maxUsageReportDays = 90
+
+type UsageReportResponse struct {
+	From    string                    `json:"from"`
+	To      string                    `json:"to"`
+	GroupBy string                    `json:"group_by"`
+	Total   *services.UsageTotals     `json:"total"`
+	Rows    []services.UsageReportRow `json:"rows"`
+}
+
+// GetUsageReportHandler reports a tenant's token usage and cost between two dates, grouped by user,
+// video, endpoint or model. Dates default to the last 30 days. The report covers all of the tenant's
+// users, so users cannot read it.
+func GetUsageReportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireService(w, r, "Usage reports are only available to services") {
+		return
+	}
+	query := r.URL.Query()
+
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = services.UsageByUser
+	}
+	switch groupBy {
+	case services.UsageByUser, services.UsageByVideo, services.UsageByEndpoint, services.UsageByModel:
+	default:
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "group_by must be user, video, endpoint or model")
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, err := parseDate(query.Get("to"), today)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid to date, expected YYYY-MM-DD")
+		return
+	}
+	from, err := parseDate(query.Get("from"), to.AddDate(0, 0, -29))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid from date, expected YYYY-MM-DD")
+		return
+	}
+	if from.After(to) || to.Sub(from) >= maxUsageReportDays*24*time.Hour {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Date range must be between 1 and 90 days")
+		return
+	}
+
+	rows, total, err := services.GetUsageReport(r.Context(), from, to, groupBy, query.Get("key"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to build usage report", "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageReportResponse{
+		From:    from.Format("2006-01-02"),
+		To:      to.Format("2006-01-02"),
+		GroupBy: groupBy,
+		Total:   total,
+		Rows:    rows,
+	})
+}
+
+// GetBudgetHandler returns a user's spend this month against their plan's budget. Users see their
+// own budget; services name the user with userId.
+func GetBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID := services.CallerFromContext(r.Context()).UserID
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "userId is required")
+		return
+	}
+
+	budget, err := services.GetBudget(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve budget", "error", err)
+		apierror.WriteError(w, err, "Failed to retrieve budget")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
+
+// parseDate parses a YYYY-MM-DD date, returning fallback for an empty value
+func parseDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
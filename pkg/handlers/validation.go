@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of free-text fields, in characters
+const (
+	maxQuestionLength = 2000
+	maxReplyLength    = 2000
+	maxTitleLength    = 300
+	maxCourseVideos   = 100
+	maxRelatedLimit   = 20
+	maxQuizAnswers    = 50
+)
+
+// Seconds a timestamp may run past the end of the transcript, as videos can continue after the last caption
+const timestampGrace = 60
+
+var (
+	youTubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	languagePattern  = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+	courseIDPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Identity is the userId a request may name. Handlers take the user from the authenticated caller
+// (see middleware.Identify); it is declared so that requests naming it are not rejected.
+type Identity struct {
+	UserID string `json:"userId,omitempty"`
+}
+
+// requireService writes a forbidden response unless the request is signed by a service, for
+// endpoints that change data shared by every tenant. With auth disabled every caller is trusted.
+func requireService(w http.ResponseWriter, r *http.Request, message string) bool {
+	if config.AuthEnabled && !services.CallerFromContext(r.Context()).Service {
+		apierror.Write(w, http.StatusForbidden, apierror.Forbidden, message)
+		return false
+	}
+	return true
+}
+
+// decodeJSON decodes the request body into dst, rejecting unknown fields and trailing data, and
+// writes the error response if it cannot. With optional set an empty body is accepted.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, optional bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil && decoder.More() {
+		err = errors.New("trailing data after JSON body")
+	}
+	if err == nil || (optional && err == io.EOF) {
+		return true
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == io.EOF:
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Request body is required")
+	case errors.As(err, &typeErr):
+		apierror.WriteInvalid(w, []apierror.FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		apierror.WriteInvalid(w, []apierror.FieldError{{Field: field, Message: "unknown field"}})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Malformed JSON body")
+	default:
+		apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Invalid request payload")
+	}
+	return false
+}
+
+// validator collects every invalid field of a request so they are reported together
+type validator struct {
+	errors []apierror.FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.errors = append(v.errors, apierror.FieldError{Field: field, Message: message})
+}
+
+// check adds an error for field unless ok
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.add(field, message)
+	}
+}
+
+func (v *validator) required(field, value string) {
+	v.check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// videoID checks a YouTube video ID, which is optional unless required is set
+func (v *validator) videoID(field, value string, required bool) {
+	if value == "" {
+		v.check(!required, field, "is required")
+		return
+	}
+	v.check(youTubeIDPattern.MatchString(value), field, "must be an 11 character YouTube video ID")
+}
+
+func (v *validator) maxLength(field, value string, max int) {
+	v.check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// text checks a required free-text field such as a question
+func (v *validator) text(field, value string, max int) {
+	v.required(field, value)
+	v.maxLength(field, value, max)
+}
+
+func (v *validator) language(field, value string) {
+	v.check(value == "" || languagePattern.MatchString(value), field, "must be a language code such as en or pt-BR")
+}
+
+func (v *validator) persona(field, value string) {
+	if _, err := services.NormalizePersona(value); err != nil {
+		v.add(field, fmt.Sprintf("must be one of %s", strings.Join(services.Personas, ", ")))
+	}
+}
+
+// timestamp checks a position in a video, in seconds. It cannot be past the end of the video's
+// transcript; videos without a transcript yet are not checked against their length.
+func (v *validator) timestamp(ctx context.Context, field string, videoID string, seconds int) {
+	if seconds < 0 {
+		v.add(field, "must not be negative")
+		return
+	}
+	if videoID == "" || !youTubeIDPattern.MatchString(videoID) {
+		return
+	}
+	duration, err := services.GetTranscriptDuration(ctx, videoID)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping timestamp check", "video_id", videoID, "error", err)
+		return
+	}
+	if duration > 0 && float64(seconds) > duration+timestampGrace {
+		v.add(field, fmt.Sprintf("must be within the video (%d seconds)", int(duration)))
+	}
+}
+
+// valid writes the error response listing the invalid fields, if any, and reports whether there were none
+func (v *validator) valid(w http.ResponseWriter) bool {
+	if len(v.errors) == 0 {
+		return true
+	}
+	apierror.WriteInvalid(w, v.errors)
+	return false
+}
@@ -0,0 +1,102 @@
+// Package logging sets up the service's structured logger. Records logged with a request's context
+// carry its request ID and the other attributes added to the context, and secrets are scrubbed
+// from every record. User content is only logged through Payload, which redacts it unless payload
+// logging is switched on for debugging.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type contextKey struct{}
+
+// payloads enables logging of user content, see Payload
+var payloads bool
+
+// Secrets scrubbed from every log record: OpenAI API keys and bearer tokens
+var secretPattern = regexp.MustCompile(`sk-[A-Za-z0-9_-]{16,}|(?i)bearer\s+[A-Za-z0-9._~+/=-]{8,}`)
+
+// Init installs the default logger. level is debug, info, warn or error; format is json or text.
+// With logPayloads set, user content such as questions and answers is logged in full.
+func Init(level, format string, logPayloads bool) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	payloads = logPayloads
+
+	options := &slog.HandlerOptions{Level: lvl, ReplaceAttr: scrubSecrets}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, options)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	if payloads {
+		slog.Warn("Payload logging is enabled, user content will appear in logs")
+	}
+	return nil
+}
+
+// With returns a context whose log records carry the given attributes, such as the request ID
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(existing)+len(attrs))
+	combined = append(combined, existing...)
+	combined = append(combined, attrs...)
+	return context.WithValue(ctx, contextKey{}, combined)
+}
+
+// Payload returns an attribute holding user content. Unless payload logging is enabled only the
+// content's length is logged.
+func Payload(key, content string) slog.Attr {
+	if payloads {
+		return slog.String(key, content)
+	}
+	return slog.String(key, fmt.Sprintf("[redacted %d bytes]", len(content)))
+}
+
+// contextHandler adds the attributes stored in a record's context to the record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// scrubSecrets replaces API keys and bearer tokens in any logged string, including error messages
+func scrubSecrets(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); secretPattern.MatchString(s) {
+			a.Value = slog.StringValue(secretPattern.ReplaceAllString(s, "[REDACTED]"))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok && secretPattern.MatchString(err.Error()) {
+			a.Value = slog.StringValue(secretPattern.ReplaceAllString(err.Error(), "[REDACTED]"))
+		}
+	}
+	return a
+}
@@ -0,0 +1,159 @@
+// Package metrics defines the Prometheus metrics of the service, served on /metrics
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ai_service"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"route", "method"})
+
+	modelDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "model_request_duration_seconds",
+		Help:      "Latency of OpenAI API calls, including retries, by endpoint, model and API.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"endpoint", "model", "api"})
+
+	modelErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "model_request_errors_total",
+		Help:      "Failed OpenAI API calls, after retries, by endpoint, model, API and error class.",
+	}, []string{"endpoint", "model", "api", "class"})
+
+	modelRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "model_request_retries_total",
+		Help:      "Retried OpenAI API attempts by endpoint, model and API.",
+	}, []string{"endpoint", "model", "api"})
+
+	runPolls = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "assistant_run_poll_iterations",
+		Help:      "Status polls per assistant run, by endpoint and final run status.",
+		Buckets:   []float64{1, 2, 3, 5, 8, 13, 21, 34, 55, 89},
+	}, []string{"endpoint", "status"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Lookups of generated content caches by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed Redis commands by command. Missing keys are not errors.",
+	}, []string{"command"})
+
+	tokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Model tokens used by endpoint, model and type (prompt or completion).",
+	}, []string{"endpoint", "model", "type"})
+
+	usageCost = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "usage_cost_usd_total",
+		Help:      "Estimated cost of model usage in USD by endpoint and model.",
+	}, []string{"endpoint", "model"})
+)
+
+// labelOr returns value, or "none" for an empty label so series stay readable
+func labelOr(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// ObserveRequest records a completed HTTP request
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(duration.Seconds())
+}
+
+// ObserveModelCall records an OpenAI API call. class is empty for a successful call, otherwise
+// the kind of failure, e.g. rate_limited, timeout, client_error, server_error or network.
+func ObserveModelCall(endpoint, model, api string, duration time.Duration, class string) {
+	endpoint, model = labelOr(endpoint), labelOr(model)
+	modelDuration.WithLabelValues(endpoint, model, api).Observe(duration.Seconds())
+	if class != "" {
+		modelErrors.WithLabelValues(endpoint, model, api, class).Inc()
+	}
+}
+
+// ModelRetry records a retried OpenAI API attempt
+func ModelRetry(endpoint, model, api string) {
+	modelRetries.WithLabelValues(labelOr(endpoint), labelOr(model), api).Inc()
+}
+
+// ObserveRunPolls records how many status polls an assistant run took before it ended
+func ObserveRunPolls(endpoint, status string, polls int) {
+	runPolls.WithLabelValues(labelOr(endpoint), status).Observe(float64(polls))
+}
+
+// CacheLookup records a lookup of a generated content cache such as summary or glossary
+func CacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// Tokens records model token usage and its estimated cost
+func Tokens(endpoint, model string, promptTokens, completionTokens int, costUSD float64) {
+	endpoint, model = labelOr(endpoint), labelOr(model)
+	tokens.WithLabelValues(endpoint, model, "prompt").Add(float64(promptTokens))
+	tokens.WithLabelValues(endpoint, model, "completion").Add(float64(completionTokens))
+	usageCost.WithLabelValues(endpoint, model).Add(costUSD)
+}
+
+// RedisHook counts failed Redis commands; add it to a client with AddHook
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		redisErrors.WithLabelValues(strings.ToLower(cmd.Name())).Inc()
+	}
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			redisErrors.WithLabelValues(strings.ToLower(cmd.Name())).Inc()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Headers of service-to-service requests
+const (
+	signatureHeader = "X-Signature" // Hex HMAC-SHA256 of the canonical request
+	timestampHeader = "X-Timestamp" // Unix seconds the request was signed at
+	userHeader      = "X-User-Id"   // User the service acts for, optional
+	tenantHeader    = "X-Tenant-Id" // Tenant the service acts for, optional
+)
+
+// How far a signed request's timestamp may be from now, limiting replays
+const maxSignatureSkew = 5 * time.Minute
+
+// userClaims are the claims of a user token. The subject is the user ID.
+type userClaims struct {
+	TenantID string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// jwtKey verifies user tokens, set up by InitAuth
+var jwtKey interface{}
+
+// InitAuth loads the keys user tokens are verified with. Without a service secret or token key
+// every request would be rejected, so that is an error unless auth is disabled.
+func InitAuth() error {
+	if !config.AuthEnabled {
+		slog.Warn("Authentication is disabled, /ai/* routes trust the userId in requests")
+		return nil
+	}
+
+	switch {
+	case config.JWTPublicKey != "":
+		pem := []byte(strings.ReplaceAll(config.JWTPublicKey, `\n`, "\n"))
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			jwtKey = key
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			jwtKey = key
+		} else {
+			return fmt.Errorf("JWT_PUBLIC_KEY is not an RSA or ECDSA public key")
+		}
+	case config.JWTSecret != "":
+		jwtKey = []byte(config.JWTSecret)
+	}
+
+	if jwtKey == nil && config.ServiceAuthSecret == "" {
+		return fmt.Errorf("authentication is enabled but neither SERVICE_AUTH_SECRET nor JWT_SECRET/JWT_PUBLIC_KEY is set")
+	}
+	return nil
+}
+
+// signaturePayload is what a service signs: the timestamp, method, path with query, the user and
+// tenant it acts for and the SHA-256 of the body, separated by newlines
+func signaturePayload(r *http.Request, timestamp string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		timestamp,
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get(userHeader),
+		r.Header.Get(tenantHeader),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+// verifyServiceSignature checks the HMAC signature of a service request. The body is restored
+// for the handler.
+func verifyServiceSignature(r *http.Request) (*services.Caller, error) {
+	if config.ServiceAuthSecret == "" {
+		return nil, errors.New("service authentication is not configured")
+	}
+
+	timestamp := r.Header.Get(timestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing or invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return nil, errors.New("timestamp outside the allowed window")
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.New("failed to read body")
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.ServiceAuthSecret))
+	mac.Write(signaturePayload(r, timestamp, body))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	return &services.Caller{
+		UserID:   r.Header.Get(userHeader),
+		TenantID: r.Header.Get(tenantHeader),
+		Service:  true,
+	}, nil
+}
+
+// verifyUserToken checks a user's bearer token and returns the user and tenant it was issued for
+func verifyUserToken(tokenString string) (*services.Caller, error) {
+	if jwtKey == nil {
+		return nil, errors.New("user authentication is not configured")
+	}
+
+	options := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithLeeway(30 * time.Second)}
+	switch jwtKey.(type) {
+	case []byte:
+		options = append(options, jwt.WithValidMethods([]string{"HS256"}))
+	default:
+		options = append(options, jwt.WithValidMethods([]string{"RS256", "ES256"}))
+	}
+	if config.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(config.JWTIssuer))
+	}
+	if config.JWTAudience != "" {
+		options = append(options, jwt.WithAudience(config.JWTAudience))
+	}
+
+	var claims userClaims
+	if _, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	}, options...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &services.Caller{UserID: claims.Subject, TenantID: claims.TenantID}, nil
+}
+
+// Authenticate requires every request to be signed by a service or carry a user's bearer token,
+// and records the authenticated user and tenant in the request context for Identify
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.AuthEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var caller *services.Caller
+		var err error
+		if r.Header.Get(signatureHeader) != "" {
+			caller, err = verifyServiceSignature(r)
+		} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			caller, err = verifyUserToken(strings.TrimSpace(token))
+		} else {
+			err = errors.New("no credentials")
+		}
+
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rejected unauthenticated request", "path", r.URL.Path, "client_ip", clientIP(r), "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ai-service"`)
+			apierror.Write(w, http.StatusUnauthorized, apierror.Unauthorized, "Missing or invalid credentials")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(services.WithCaller(r.Context(), caller)))
+	})
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+// sign signs a request the way calling services do
+func sign(t *testing.T, secret, method, target, user, tenant, body string, at time.Time) (signature, timestamp string) {
+	t.Helper()
+	timestamp = strconv.FormatInt(at.Unix(), 10)
+	bodyHash := sha256.Sum256([]byte(body))
+	payload := strings.Join([]string{timestamp, method, target, user, tenant, hex.EncodeToString(bodyHash[:])}, "\n")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)), timestamp
+}
+
+func TestSignaturePayload(t *testing.T) {
+	r := httptest.NewRequest("POST", "/ai/generate-quiz?language=fr", nil)
+	r.Header.Set(userHeader, "user-1")
+	r.Header.Set(tenantHeader, "tenant-1")
+
+	bodyHash := sha256.Sum256([]byte(`{"video_id":"abc"}`))
+	want := "1700000000\nPOST\n/ai/generate-quiz?language=fr\nuser-1\ntenant-1\n" + hex.EncodeToString(bodyHash[:])
+	if got := string(signaturePayload(r, "1700000000", []byte(`{"video_id":"abc"}`))); got != want {
+		t.Errorf("signaturePayload() = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyServiceSignature(t *testing.T) {
+	secret := config.ServiceAuthSecret
+	config.ServiceAuthSecret = testSecret
+	defer func() { config.ServiceAuthSecret = secret }()
+
+	const (
+		target = "/ai/generate-quiz?language=fr"
+		body   = `{"video_id":"dQw4w9WgXcQ"}`
+	)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		secret     string // Secret the request is signed with
+		signedAt   time.Time
+		signedBody string // Body the signature covers
+		sentBody   string // Body actually sent
+		signedUser string // User the signature covers
+		sentUser   string // User header actually sent
+		signature  string // Overrides the computed signature when set
+		wantErr    string
+	}{
+		{name: "valid", secret: testSecret, signedAt: now, signedBody: body, sentBody: body, signedUser: "user-1", sentUser: "user-1"},
+		{name: "valid without user", secret: testSecret, signedAt: now, signedBody: body, sentBody: body},
+		{name: "slight clock skew", secret: testSecret, signedAt: now.Add(maxSignatureSkew - time.Minute), signedBody: body, sentBody: body},
+		{name: "wrong secret", secret: "other", signedAt: now, signedBody: body, sentBody: body, wantErr: "signature mismatch"},
+		{name: "tampered body", secret: testSecret, signedAt: now, signedBody: body, sentBody: `{"video_id":"aaaaaaaaaaa"}`, wantErr: "signature mismatch"},
+		{name: "swapped user", secret: testSecret, signedAt: now, signedBody: body, sentBody: body, signedUser: "user-1", sentUser: "user-2", wantErr: "signature mismatch"},
+		{name: "expired timestamp", secret: testSecret, signedAt: now.Add(-maxSignatureSkew - time.Minute), signedBody: body, sentBody: body, wantErr: "timestamp outside the allowed window"},
+		{name: "future timestamp", secret: testSecret, signedAt: now.Add(maxSignatureSkew + time.Minute), signedBody: body, sentBody: body, wantErr: "timestamp outside the allowed window"},
+		{name: "malformed signature", secret: testSecret, signedAt: now, signedBody: body, sentBody: body, signature: "not-hex", wantErr: "malformed signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, timestamp := sign(t, tt.secret, "POST", target, tt.signedUser, "tenant-1", tt.signedBody, tt.signedAt)
+			if tt.signature != "" {
+				signature = tt.signature
+			}
+
+			r := httptest.NewRequest("POST", target, strings.NewReader(tt.sentBody))
+			r.Header.Set(signatureHeader, signature)
+			r.Header.Set(timestampHeader, timestamp)
+			r.Header.Set(tenantHeader, "tenant-1")
+			if tt.sentUser != "" {
+				r.Header.Set(userHeader, tt.sentUser)
+			}
+
+			caller, err := verifyServiceSignature(r)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("verifyServiceSignature() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyServiceSignature() error = %v", err)
+			}
+			if !caller.Service || caller.UserID != tt.sentUser || caller.TenantID != "tenant-1" {
+				t.Errorf("verifyServiceSignature() caller = %+v", caller)
+			}
+
+			// The handler still gets the body
+			if restored, _ := io.ReadAll(r.Body); string(restored) != tt.sentBody {
+				t.Errorf("body after verification = %q, want %q", restored, tt.sentBody)
+			}
+		})
+	}
+}
+
+func TestVerifyServiceSignatureWithoutSecret(t *testing.T) {
+	secret := config.ServiceAuthSecret
+	config.ServiceAuthSecret = ""
+	defer func() { config.ServiceAuthSecret = secret }()
+
+	r := httptest.NewRequest("POST", "/ai/transcripts", nil)
+	if _, err := verifyServiceSignature(r); err == nil {
+		t.Error("verifyServiceSignature() accepted a request with no secret configured")
+	}
+}
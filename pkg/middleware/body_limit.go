@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Largest request body accepted by endpoints without their own limit
+const defaultBodyLimit = 64 << 10
+
+// Endpoints that take transcripts or base64 frames accept larger bodies
+var bodyLimits = map[string]int64{
+	"init-session":  2 << 20,
+	"ask-question":  8 << 20,
+	"ingest-frames": 32 << 20,
+	"transcripts":   8 << 20,
+}
+
+// LimitBody rejects request bodies over the endpoint's size limit before anything reads them.
+// The body is buffered so authentication, Identify and the handler can each read it.
+func LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, ok := bodyLimits[endpointName(r)]
+		if !ok {
+			limit = defaultBodyLimit
+		}
+		if r.ContentLength > limit {
+			writeTooLarge(w, limit)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		r.Body.Close()
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeTooLarge(w, limit)
+				return
+			}
+			apierror.Write(w, http.StatusBadRequest, apierror.InvalidRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.PayloadTooLarge,
+		fmt.Sprintf("Request body exceeds %d KB", limit>>10))
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Budget rejects requests of users who have spent their monthly budget, before any model is called.
+// Usage reports stay reachable so users can see what they spent.
+func Budget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller := services.CallerFromContext(r.Context())
+		if strings.HasPrefix(caller.Endpoint, "usage") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := services.CheckBudget(r.Context()); errors.Is(err, services.ErrBudgetExceeded) {
+			slog.InfoContext(r.Context(), "Monthly budget exceeded")
+			apierror.Write(w, http.StatusPaymentRequired, apierror.BudgetExceeded, "Monthly usage budget exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
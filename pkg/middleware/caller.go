@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/logging"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// readCaller returns the userId and video a request is made for, from the URL or the JSON body.
+// The body is restored so the handler can still decode it.
+func readCaller(r *http.Request) *services.Caller {
+	query := r.URL.Query()
+	caller := &services.Caller{
+		UserID:   query.Get("userId"),
+		VideoID:  mux.Vars(r)["videoID"],
+		Endpoint: endpointName(r),
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if r.Body == nil || (contentType != "" && !strings.HasPrefix(contentType, "application/json")) {
+		return caller
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return caller
+	}
+
+	var payload struct {
+		UserID  string `json:"userId"`
+		VideoID string `json:"video_id"`
+	}
+	json.Unmarshal(body, &payload)
+	if caller.UserID == "" {
+		caller.UserID = payload.UserID
+	}
+	if caller.VideoID == "" {
+		caller.VideoID = payload.VideoID
+	}
+	return caller
+}
+
+// endpointName returns the route template without the /ai/ prefix, e.g. "ask-question" or
+// "courses/{courseID}/quiz", so every course shares one limit per endpoint
+func endpointName(r *http.Request) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+	return strings.TrimPrefix(path, "/ai/")
+}
+
+// Identify records who a request is made for in its context, for rate limiting, usage accounting,
+// tenant isolation and the request's log records. The user comes from the authenticated token;
+// only services, and every caller when auth is disabled, may name the user in the request instead.
+func Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated := services.CallerFromContext(r.Context())
+		caller := readCaller(r)
+		caller.TenantID = authenticated.TenantID
+		caller.Service = authenticated.Service
+		if authenticated.UserID != "" || (config.AuthEnabled && !authenticated.Service) {
+			caller.UserID = authenticated.UserID
+		}
+
+		ctx := services.WithCaller(r.Context(), caller)
+		ctx = logging.With(ctx, slog.String("user_id", caller.UserID), slog.String("tenant_id", caller.Tenant()), slog.String("endpoint", caller.Endpoint))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
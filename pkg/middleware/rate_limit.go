@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/apierror"
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/services"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientIP returns the caller's address. X-Forwarded-For is only honoured when the request comes
+// from a trusted proxy, since anyone can set it; the caller is then the last hop that is not itself
+// a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit limits requests per user (or per client IP for anonymous requests) and endpoint,
+// using the limits of the user's plan. Services acting for no user are not limited, as all their
+// calls come from a few addresses. Redis failures let requests through.
+func RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		caller := services.CallerFromContext(ctx)
+		userID, endpoint := caller.UserID, caller.Endpoint
+		if caller.Service && userID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := services.RateLimitFor(services.GetUserPlan(ctx, userID), endpoint)
+		if limit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := "user:" + userID
+		if userID == "" {
+			identity = "ip:" + clientIP(r)
+		}
+		result, err := services.CheckRateLimit(ctx, identity, endpoint, *limit)
+		if err != nil {
+			slog.WarnContext(ctx, "Rate limiting skipped", "identity", identity, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.RetryAfter).Unix(), 10))
+			slog.InfoContext(ctx, "Rate limit exceeded", "identity", identity)
+			apierror.Write(w, http.StatusTooManyRequests, apierror.RateLimited, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
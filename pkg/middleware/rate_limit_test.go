@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := config.TrustedProxies
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.5/32")}
+	defer func() { config.TrustedProxies = trusted }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct request", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "spoofed header from an untrusted peer", remoteAddr: "203.0.113.7:5000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:5000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "client prepends a fake hop", remoteAddr: "10.1.2.3:5000", forwarded: []string{"1.1.1.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.1.2.3:5000", forwarded: []string{"198.51.100.1, 192.168.1.5", "10.9.9.9"}, want: "198.51.100.1"},
+		{name: "trusted proxy without the header", remoteAddr: "10.1.2.3:5000", want: "10.1.2.3"},
+		{name: "only trusted hops", remoteAddr: "10.1.2.3:5000", forwarded: []string{"10.0.0.1"}, want: "10.0.0.1"},
+		{name: "ipv4-mapped proxy address", remoteAddr: "[::ffff:10.1.2.3]:5000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ai/ask-question", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
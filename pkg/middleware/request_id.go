@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/logging"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// requestIDHeader carries the request ID in both directions, so a caller's ID is kept end to end
+const requestIDHeader = "X-Request-Id"
+
+// Request IDs accepted from callers; anything else is replaced so IDs cannot forge log lines
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID gives every request an ID, taken from X-Request-Id if the caller sent a valid one. The
+// ID is returned in X-Request-Id and attached to every log record of the request, and each request
+// is logged once it completes.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := logging.With(r.Context(), slog.String("request_id", requestID))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "Request completed",
+			"method", r.Method,
+			"route", endpointName(r),
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", clientIP(r),
+		)
+	})
+}
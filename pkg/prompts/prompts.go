@@ -0,0 +1,211 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Template names available in every prompt version
+const (
+	SummarySystem         = "summary_system"
+	Summary               = "summary"
+	QuizSystem            = "quiz_system"
+	Quiz                  = "quiz"
+	QuizAdaptive          = "quiz_adaptive"
+	AssistantInstructions = "assistant_instructions"
+	Question              = "question"
+	Guardrails            = "guardrails"
+	TutoringHint          = "tutoring_hint"
+	TutoringReveal        = "tutoring_reveal"
+	TutoringAttempt       = "tutoring_attempt"
+
+	UnderstandingCheckSystem      = "understanding_check_system"
+	UnderstandingCheck            = "understanding_check"
+	UnderstandingEvaluationSystem = "understanding_evaluation_system"
+	UnderstandingEvaluation       = "understanding_evaluation"
+
+	GlossarySystem    = "glossary_system"
+	Glossary          = "glossary"
+	GlossaryReference = "glossary_reference"
+
+	CourseSummary               = "course_summary"
+	CourseQuiz                  = "course_quiz"
+	CourseAssistantInstructions = "course_assistant_instructions"
+
+	TranscriptCleanupSystem = "transcript_cleanup_system"
+	TranscriptCleanup       = "transcript_cleanup"
+
+	TranslationSystem       = "translation_system"
+	Translation             = "translation"
+	LanguageDetectionSystem = "language_detection_system"
+	LanguageDetection       = "language_detection"
+	FrameExtractionSystem   = "frame_extraction_system"
+	FrameExtraction         = "frame_extraction"
+
+	UntrustedContent = "untrusted_content"
+)
+
+// Persona returns the template name holding a persona's instructions
+func Persona(persona string) string {
+	return "persona_" + persona
+}
+
+//go:embed templates
+var embedded embed.FS
+
+var (
+	registry       = make(map[string]*template.Template)
+	versions       []string
+	defaultVersion string
+	mu             sync.RWMutex
+)
+
+// Init loads the built-in prompt versions and, if dir is set, any versions found there.
+// A version in dir overrides the built-in version with the same name. preferred selects
+// the default version; if empty the latest version is used.
+func Init(dir, preferred string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry = make(map[string]*template.Template)
+
+	builtin, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return err
+	}
+	if err := loadVersions(builtin); err != nil {
+		return err
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err == nil {
+			if err := loadVersions(os.DirFS(dir)); err != nil {
+				return err
+			}
+		} else {
+			slog.Warn("Prompt directory not found, using built-in prompts only", "dir", dir)
+		}
+	}
+
+	versions = versions[:0]
+	for v := range registry {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+
+	defaultVersion = versions[len(versions)-1]
+	if preferred != "" {
+		if _, ok := registry[preferred]; !ok {
+			return fmt.Errorf("prompt version %q not found", preferred)
+		}
+		defaultVersion = preferred
+	}
+
+	slog.Info("Loaded prompt versions", "versions", versions, "default", defaultVersion)
+	return nil
+}
+
+// loadVersions parses every <version>/*.tmpl file in fsys, one template set per version directory
+func loadVersions(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read prompt directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		version := entry.Name()
+		files, err := fs.Glob(fsys, path.Join(version, "*.tmpl"))
+		if err != nil || len(files) == 0 {
+			continue
+		}
+
+		tmpl := template.New(version).Option("missingkey=error").Funcs(template.FuncMap{"join": strings.Join, "untrusted": Untrusted})
+		for _, file := range files {
+			content, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				return fmt.Errorf("failed to read prompt %s: %v", file, err)
+			}
+			name := strings.TrimSuffix(path.Base(file), ".tmpl")
+			if _, err := tmpl.New(name).Parse(string(content)); err != nil {
+				return fmt.Errorf("failed to parse prompt %s: %v", file, err)
+			}
+		}
+		registry[version] = tmpl
+	}
+	return nil
+}
+
+// compareVersions orders versions like v1 < v2 < v10, falling back to string order
+func compareVersions(a, b string) int {
+	na, errA := strconv.Atoi(strings.TrimPrefix(a, "v"))
+	nb, errB := strconv.Atoi(strings.TrimPrefix(b, "v"))
+	if errA == nil && errB == nil {
+		return na - nb
+	}
+	return strings.Compare(a, b)
+}
+
+// ResolveVersion returns the requested version if it exists, otherwise the default version
+func ResolveVersion(requested string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if _, ok := registry[requested]; ok {
+		return requested
+	}
+	if requested != "" {
+		slog.Warn("Unknown prompt version, using the default", "requested", requested, "default", defaultVersion)
+	}
+	return defaultVersion
+}
+
+// Versions lists the loaded prompt versions in ascending order
+func Versions() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string{}, versions...)
+}
+
+// Untrusted wraps text that comes from videos or requesters, such as a transcript, in delimiters
+// the prompts tell the model never to take instructions from. Delimiter-like sequences inside the
+// text are broken up so it cannot close its block early.
+func Untrusted(label, text string) string {
+	for strings.Contains(text, "<<<") || strings.Contains(text, ">>>") {
+		text = strings.NewReplacer("<<<", "<< <", ">>>", "> >>").Replace(text)
+	}
+	label = strings.ToUpper(label)
+	return fmt.Sprintf("<<<BEGIN UNTRUSTED %s>>>\n%s\n<<<END UNTRUSTED %s>>>", label, text, label)
+}
+
+// Render executes the named template of the given version
+func Render(version, name string, data interface{}) (string, error) {
+	mu.RLock()
+	tmpl, ok := registry[version]
+	mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("prompt version %q not found", version)
+	}
+
+	t := tmpl.Lookup(name)
+	if t == nil {
+		return "", fmt.Errorf("prompt %q not found in version %s", name, version)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s/%s: %v", version, name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Mastery assumed for a topic the learner has not been quizzed on yet
+	initialMastery = 0.5
+	// Weight of the newest answer in the per-topic moving average
+	masteryLearningRate = 0.3
+	// Topics below this mastery are targeted by adaptive quizzes
+	weakTopicThreshold = 0.6
+	// Topics at or above this mastery are considered mastered
+	strongTopicThreshold = 0.85
+	// Topics tracked per learner and video; answers on further new topics are not tracked
+	maxMasteryTopics = 50
+	// Weak and strong topics each passed to the adaptive quiz prompt
+	maxPromptTopics = 10
+	// Topic labels are cut to this many characters
+	MaxTopicLength = 100
+	masteryTTL     = 30 * 24 * time.Hour
+)
+
+// Quiz difficulties, easiest first
+var QuizDifficulties = []string{"easy", "medium", "hard"}
+
+// difficultyWeight scales how much an answer moves mastery. A correct answer on a hard question
+// is stronger evidence of mastery than one on an easy question, and a wrong answer on an easy
+// question is stronger evidence against it.
+var difficultyWeight = map[string]float64{"easy": 0.67, "medium": 1, "hard": 1.33}
+
+// updateMasteryScript applies answers to the mastery hash atomically, so concurrent attempts
+// do not overwrite each other. ARGV holds the initial mastery, TTL in seconds and topic cap,
+// followed by a (topic, rate, score) triple per answer. It returns the updated hash.
+var updateMasteryScript = redis.NewScript(`
+local initial = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local maxTopics = tonumber(ARGV[3])
+for i = 4, #ARGV, 3 do
+  local topic = ARGV[i]
+  local rate = tonumber(ARGV[i + 1])
+  local score = tonumber(ARGV[i + 2])
+  local current = tonumber(redis.call('HGET', KEYS[1], topic))
+  if not current and redis.call('HLEN', KEYS[1]) < maxTopics then
+    current = initial
+  end
+  if current then
+    redis.call('HSET', KEYS[1], topic, tostring(current + rate * (score - current)))
+  end
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  redis.call('EXPIRE', KEYS[1], ttl)
+end
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// QuizAnswer is the learner's result on a single quiz question
+type QuizAnswer struct {
+	Question   string `json:"question"`
+	Topic      string `json:"topic"`
+	Difficulty string `json:"difficulty,omitempty"`
+	Correct    bool   `json:"correct"`
+}
+
+func masteryKey(ctx context.Context, userID, videoID string) string {
+	return tenantKey(ctx, "mastery", userID+":"+videoID)
+}
+
+// RecordQuizAttempt updates the learner's per-topic mastery from a quiz attempt, returning the new
+// mastery. Only the mastery is kept, not the attempt itself.
+func RecordQuizAttempt(ctx context.Context, userID, videoID string, answers []QuizAnswer) (map[string]float64, error) {
+	// Exponential moving average per topic, so recent answers count the most
+	args := []interface{}{initialMastery, int(masteryTTL.Seconds()), maxMasteryTopics}
+	for _, answer := range answers {
+		topic := normalizeTopic(answer.Topic)
+		if topic == "" {
+			continue
+		}
+		weight, ok := difficultyWeight[answer.Difficulty]
+		if !ok {
+			weight = 1
+		}
+		rate, score := masteryLearningRate*weight, 1.0
+		if !answer.Correct {
+			rate, score = masteryLearningRate/weight, 0
+		}
+		args = append(args, topic, rate, score)
+	}
+
+	values, err := updateMasteryScript.Run(ctx, RedisClient, []string{masteryKey(ctx, userID, videoID)}, args...).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store mastery in Redis: %v", err)
+	}
+
+	mastery := make(map[string]float64, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		if m, err := strconv.ParseFloat(values[i+1], 64); err == nil {
+			mastery[values[i]] = m
+		}
+	}
+
+	slog.InfoContext(ctx, "Recorded quiz attempt", "video_id", videoID, "answers", len(answers))
+	return mastery, nil
+}
+
+// GetMastery returns the learner's mastery per topic for a video, between 0 and 1
+func GetMastery(ctx context.Context, userID, videoID string) (map[string]float64, error) {
+	values, err := RedisClient.HGetAll(ctx, masteryKey(ctx, userID, videoID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving mastery from Redis: %v", err)
+	}
+
+	mastery := make(map[string]float64, len(values))
+	for topic, value := range values {
+		if m, err := strconv.ParseFloat(value, 64); err == nil {
+			mastery[topic] = m
+		}
+	}
+	return mastery, nil
+}
+
+func normalizeTopic(topic string) string {
+	topic = strings.ToLower(strings.Join(strings.Fields(topic), " "))
+	if r := []rune(topic); len(r) > MaxTopicLength {
+		topic = string(r[:MaxTopicLength])
+	}
+	return topic
+}
+
+// masteryDifficulty maps the learner's average mastery to a target quiz difficulty
+func masteryDifficulty(mastery map[string]float64) string {
+	if len(mastery) == 0 {
+		return "medium"
+	}
+	var total float64
+	for _, m := range mastery {
+		total += m
+	}
+	switch avg := total / float64(len(mastery)); {
+	case avg < 0.4:
+		return "easy"
+	case avg < 0.75:
+		return "medium"
+	default:
+		return "hard"
+	}
+}
+
+// GenerateAdaptiveQuiz generates follow-up questions that target the learner's weak topics at a difficulty matching their mastery
+func GenerateAdaptiveQuiz(ctx context.Context, transcript, language, promptVersion string, mastery map[string]float64) (map[string]interface{}, error) {
+	if transcript == "" {
+		return nil, fmt.Errorf("transcript is empty")
+	}
+
+	var weak, strong []string
+	for topic, m := range mastery {
+		switch {
+		case m < weakTopicThreshold:
+			weak = append(weak, topic)
+		case m >= strongTopicThreshold:
+			strong = append(strong, topic)
+		}
+	}
+	// Weakest topics first, and only the few the quiz can cover
+	sort.Slice(weak, func(i, j int) bool { return mastery[weak[i]] < mastery[weak[j]] })
+	sort.Slice(strong, func(i, j int) bool { return mastery[strong[i]] > mastery[strong[j]] })
+	if len(weak) > maxPromptTopics {
+		weak = weak[:maxPromptTopics]
+	}
+	if len(strong) > maxPromptTopics {
+		strong = strong[:maxPromptTopics]
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.QuizSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.QuizAdaptive, map[string]interface{}{
+		"Transcript":   transcript,
+		"Language":     LanguageName(language),
+		"WeakTopics":   weak,
+		"StrongTopics": strong,
+		"Difficulty":   masteryDifficulty(mastery),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := CallGPT2(ctx, prompt, systemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("GPT call failed: %v", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tenant of requests that are not authenticated as belonging to one
+const DefaultTenant = "default"
+
+// Caller identifies who a request is made for and what it is about, so model usage can be
+// attributed to it and data kept apart per tenant
+type Caller struct {
+	UserID   string
+	TenantID string
+	Service  bool // Authenticated with the service secret, so trusted to name the user it acts for
+	VideoID  string
+	Endpoint string // Route without the /ai/ prefix, e.g. "ask-question"
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying the caller
+func WithCaller(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller of a request, or an empty caller for background work
+func CallerFromContext(ctx context.Context) *Caller {
+	if caller, ok := ctx.Value(callerKey{}).(*Caller); ok {
+		return caller
+	}
+	return &Caller{}
+}
+
+// Tenant returns the caller's tenant, or DefaultTenant
+func (c *Caller) Tenant() string {
+	if c.TenantID == "" {
+		return DefaultTenant
+	}
+	return c.TenantID
+}
+
+// tenantKey prefixes a Redis key namespace with the tenant of the request, e.g.
+// tenantKey(ctx, "summary", "en:VIDEO") is "summary:<tenant>:en:VIDEO"
+func tenantKey(ctx context.Context, namespace, key string) string {
+	return fmt.Sprintf("%s:%s:%s", namespace, CallerFromContext(ctx).Tenant(), key)
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Longest time a single cue stays on screen, in seconds
+	maxCueDuration = 7.0
+	// Shortest time a cue stays on screen when the next cue leaves room, in seconds
+	minCueDuration = 1.0
+	// Display length given to the last line of a transcript without a following timestamp
+	lastCueDuration = 4.0
+	// Characters per caption line and lines per cue
+	maxCueLineLength = 42
+	maxCueLines      = 2
+)
+
+// Cue is a single caption shown between Start and End seconds
+type Cue struct {
+	Start float64
+	End   float64
+	Lines []string
+}
+
+// transcriptSegments converts "seconds: text" lines into segments, taking each duration from the start
+// of the next line. Lines without a timestamp continue the previous segment.
+func transcriptSegments(transcript string) []TranscriptSegment {
+	var segments []TranscriptSegment
+	for _, line := range ParseTranscriptLines(transcript) {
+		start, err := strconv.ParseFloat(line.Start, 64)
+		if err != nil {
+			if n := len(segments); n > 0 {
+				segments[n-1].Text += " " + line.Text
+			}
+			continue
+		}
+		segments = append(segments, TranscriptSegment{Start: start, Text: line.Text})
+	}
+
+	for i := range segments {
+		if i+1 < len(segments) {
+			segments[i].Duration = segments[i+1].Start - segments[i].Start
+		} else {
+			segments[i].Duration = lastCueDuration
+		}
+	}
+	return segments
+}
+
+// GetCaptionSegments returns the timed text of a video in the requested language, translating the
+// transcript if needed. An empty language returns the transcript in its own language, as ingested
+// unless cleaned is set. Translations are always made from the source transcript, which is the
+// cleaned one when cleanup applies. Returns nil segments if the video has no transcript.
+func GetCaptionSegments(ctx context.Context, videoID, requestedLang string, cleaned bool) ([]TranscriptSegment, string, error) {
+	transcript, err := GetTranscriptFromRedis(ctx, videoID)
+	if err != nil || transcript == "" {
+		return nil, "", err
+	}
+	stored, err := GetStoredTranscript(ctx, videoID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The language declared at ingestion saves a detection call
+	var sourceLang string
+	if stored != nil {
+		sourceLang = stored.Language
+	}
+	if sourceLang == "" {
+		if sourceLang, err = GetTranscriptLanguage(ctx, videoID, transcript); err != nil {
+			return nil, "", fmt.Errorf("failed to detect transcript language: %v", err)
+		}
+	}
+
+	language := NormalizeLanguage(requestedLang)
+	if (language == "" || language == sourceLang) && !cleaned {
+		if stored != nil {
+			return stored.Segments, sourceLang, nil
+		}
+		return transcriptSegments(transcript), sourceLang, nil
+	}
+
+	source, sourceSegments, err := sourceTranscript(ctx, videoID)
+	if err != nil {
+		return nil, "", err
+	}
+	if language == "" || language == sourceLang {
+		if sourceSegments != nil {
+			return sourceSegments, sourceLang, nil
+		}
+		return transcriptSegments(source), sourceLang, nil
+	}
+
+	translated, err := GetTranslatedTranscript(ctx, videoID, source, language)
+	if err != nil {
+		return nil, "", err
+	}
+	segments := transcriptSegments(translated)
+
+	// Translations keep one line per source line, so the source durations still apply
+	if len(sourceSegments) == len(segments) {
+		for i := range segments {
+			segments[i].Duration = sourceSegments[i].Duration
+		}
+	}
+	return segments, language, nil
+}
+
+// BuildCues turns segments into cues of at most maxCueLines lines of maxCueLineLength characters
+// that stay on screen between minCueDuration and maxCueDuration seconds and never overlap.
+func BuildCues(segments []TranscriptSegment) []Cue {
+	segments = NormalizeSegments(append([]TranscriptSegment(nil), segments...))
+
+	var cues []Cue
+	for i, segment := range segments {
+		end := segment.Start + segment.Duration
+		if segment.Duration <= 0 {
+			end = segment.Start + lastCueDuration
+		}
+		// Rolling captions overlap the following segment
+		if i+1 < len(segments) && end > segments[i+1].Start {
+			end = segments[i+1].Start
+		}
+
+		// Long segments are split into cues that share the segment's time by length
+		lines := wrapCueText(segment.Text)
+		total := 0
+		for _, line := range lines {
+			total += utf8.RuneCountInString(line)
+		}
+		start := segment.Start
+		for first := 0; first < len(lines); first += maxCueLines {
+			last := first + maxCueLines
+			if last > len(lines) {
+				last = len(lines)
+			}
+			cueEnd := end
+			if len(lines) > maxCueLines {
+				length := 0
+				for _, line := range lines[first:last] {
+					length += utf8.RuneCountInString(line)
+				}
+				cueEnd = start + (end-segment.Start)*float64(length)/float64(total)
+			}
+			cues = append(cues, Cue{Start: start, End: cueEnd, Lines: lines[first:last]})
+			start = cueEnd
+		}
+	}
+
+	for i := range cues {
+		cue := &cues[i]
+		if cue.End-cue.Start > maxCueDuration {
+			cue.End = cue.Start + maxCueDuration
+		}
+		if cue.End-cue.Start < minCueDuration {
+			cue.End = cue.Start + minCueDuration
+			if i+1 < len(cues) && cue.End > cues[i+1].Start {
+				cue.End = cues[i+1].Start
+			}
+		}
+	}
+	return cues
+}
+
+// wrapCueText breaks text into lines of at most maxCueLineLength characters, each ending at the
+// last space that fits. Only words longer than a line, such as unspaced CJK text, are split by character.
+func wrapCueText(text string) []string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(text) {
+		runes := []rune(word)
+		if len(line) > 0 && len(line)+1+len(runes) <= maxCueLineLength {
+			line = append(append(line, ' '), runes...)
+			continue
+		}
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+		for len(runes) > maxCueLineLength {
+			lines = append(lines, string(runes[:maxCueLineLength]))
+			runes = runes[maxCueLineLength:]
+		}
+		line = append([]rune(nil), runes...)
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// Cue text is markup in WebVTT, so &, < and > must be escaped
+var cueTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// formatCueTimestamp formats seconds as hh:mm:ss followed by the separator and milliseconds
+func formatCueTimestamp(seconds float64, separator string) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// RenderWebVTT renders cues as a WebVTT file
+func RenderWebVTT(cues []Cue) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&sb, "\n%s --> %s\n", formatCueTimestamp(cue.Start, "."), formatCueTimestamp(cue.End, "."))
+		for _, line := range cue.Lines {
+			sb.WriteString(cueTextEscaper.Replace(line) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// RenderSRT renders cues as a SubRip file
+func RenderSRT(cues []Cue) string {
+	var sb strings.Builder
+	for i, cue := range cues {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%d\n%s --> %s\n", i+1, formatCueTimestamp(cue.Start, ","), formatCueTimestamp(cue.End, ","))
+		sb.WriteString(strings.Join(cue.Lines, "\n") + "\n")
+	}
+	return sb.String()
+}
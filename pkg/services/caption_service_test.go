@@ -0,0 +1,190 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseCaptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []TranscriptSegment
+		wantErr bool
+	}{
+		{
+			name: "webvtt with header, note and tags",
+			content: "WEBVTT\n\nNOTE written by hand\n\n1\n00:00:01.000 --> 00:00:03.500 align:start\n<v Speaker>Hello</v> world\n\n" +
+				"00:01:02.250 --> 00:01:04.000\nsecond\nline\n",
+			want: []TranscriptSegment{
+				{Start: 1, Duration: 2.5, Text: "Hello world"},
+				{Start: 62.25, Duration: 1.75, Text: "second line"},
+			},
+		},
+		{
+			name:    "srt with windows line endings",
+			content: "1\r\n00:00:00,500 --> 00:00:02,000\r\nFirst cue\r\n\r\n2\r\n00:00:02,000 --> 00:00:04,000\r\nSecond cue\r\n",
+			want: []TranscriptSegment{
+				{Start: 0.5, Duration: 1.5, Text: "First cue"},
+				{Start: 2, Duration: 2, Text: "Second cue"},
+			},
+		},
+		{
+			name:    "minutes only timestamps",
+			content: "WEBVTT\n\n01:02.000 --> 01:03.000\nshort\n",
+			want:    []TranscriptSegment{{Start: 62, Duration: 1, Text: "short"}},
+		},
+		{
+			name:    "no cues",
+			content: "WEBVTT\n\nNOTE nothing here\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid timestamp",
+			content: "1\n00:00:aa,000 --> 00:00:02,000\ntext\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCaptions(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCaptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCaptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapCueText(t *testing.T) {
+	long := strings.Repeat("字", maxCueLineLength+5)
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "fits on one line",
+			text: "a short caption",
+			want: []string{"a short caption"},
+		},
+		{
+			name: "breaks at the last space that fits",
+			text: "This is a fairly long caption line that should wrap at the last space",
+			want: []string{"This is a fairly long caption line that", "should wrap at the last space"},
+		},
+		{
+			name: "splits only the word longer than a line",
+			text: "before " + long + " after",
+			want: []string{"before", long[:len("字")*maxCueLineLength], "字字字字字 after"},
+		},
+		{
+			name: "collapses whitespace",
+			text: "  spaced   out  ",
+			want: []string{"spaced out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapCueText(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapCueText() = %q, want %q", got, tt.want)
+			}
+			for _, line := range got {
+				if utf8.RuneCountInString(line) > maxCueLineLength {
+					t.Errorf("line %q is longer than %d characters", line, maxCueLineLength)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildCues(t *testing.T) {
+	// Eight words of four letters fill a line; the cue holding 78 of the 121 characters gets 78/121 of the time
+	eightWords := strings.TrimSpace(strings.Repeat("word ", 8))
+	tests := []struct {
+		name     string
+		segments []TranscriptSegment
+		want     []Cue
+	}{
+		{
+			name: "overlapping rolling captions are trimmed",
+			segments: []TranscriptSegment{
+				{Start: 0, Duration: 5, Text: "first"},
+				{Start: 3, Duration: 2, Text: "second"},
+			},
+			want: []Cue{
+				{Start: 0, End: 3, Lines: []string{"first"}},
+				{Start: 3, End: 5, Lines: []string{"second"}},
+			},
+		},
+		{
+			name:     "long cues are capped",
+			segments: []TranscriptSegment{{Start: 10, Duration: 30, Text: "slow"}},
+			want:     []Cue{{Start: 10, End: 10 + maxCueDuration, Lines: []string{"slow"}}},
+		},
+		{
+			name: "short cues are extended up to the next cue",
+			segments: []TranscriptSegment{
+				{Start: 0, Duration: 0.2, Text: "quick"},
+				{Start: 0.5, Duration: 2, Text: "next"},
+			},
+			want: []Cue{
+				{Start: 0, End: 0.5, Lines: []string{"quick"}},
+				{Start: 0.5, End: 2.5, Lines: []string{"next"}},
+			},
+		},
+		{
+			name:     "segments without a duration get the last cue duration",
+			segments: []TranscriptSegment{{Start: 4, Text: "end"}},
+			want:     []Cue{{Start: 4, End: 4 + lastCueDuration, Lines: []string{"end"}}},
+		},
+		{
+			name:     "segments longer than a cue share their time by length",
+			segments: []TranscriptSegment{{Start: 0, Duration: 6, Text: strings.Repeat("word ", 24) + "tail"}},
+			want: []Cue{
+				{Start: 0, End: 6 * 78.0 / 121, Lines: []string{eightWords, eightWords}},
+				{Start: 6 * 78.0 / 121, End: 6, Lines: []string{eightWords, "tail"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildCues(tt.segments)
+			if len(got) != len(tt.want) {
+				t.Fatalf("BuildCues() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if !approxEqual(got[i].Start, tt.want[i].Start) || !approxEqual(got[i].End, tt.want[i].End) || !reflect.DeepEqual(got[i].Lines, tt.want[i].Lines) {
+					t.Errorf("cue %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRenderCaptions(t *testing.T) {
+	cues := []Cue{{Start: 3661.5, End: 3663, Lines: []string{"a < b & c", "second"}}}
+
+	wantVTT := "WEBVTT\n\n01:01:01.500 --> 01:01:03.000\na &lt; b &amp; c\nsecond\n"
+	if got := RenderWebVTT(cues); got != wantVTT {
+		t.Errorf("RenderWebVTT() = %q, want %q", got, wantVTT)
+	}
+
+	wantSRT := "1\n01:01:01,500 --> 01:01:03,000\na < b & c\nsecond\n"
+	if got := RenderSRT(cues); got != wantSRT {
+		t.Errorf("RenderSRT() = %q, want %q", got, wantSRT)
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	const epsilon = 1e-9
+	return a-b < epsilon && b-a < epsilon
+}
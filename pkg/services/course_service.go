@@ -0,0 +1,509 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Total transcript characters sent to the model for a cumulative course quiz, split evenly between videos
+const courseQuizTranscriptBudget = 240000
+
+// Course is an ordered playlist of videos studied together
+type Course struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	VideoIDs  []string  `json:"video_ids"`
+	OwnerID   string    `json:"owner_id,omitempty"` // User who created the course, the only user who may replace it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// courseIndex is the file search index of a course's transcripts. It is stored apart from the
+// course so that indexing, which takes minutes, never writes back a course replaced meanwhile.
+type courseIndex struct {
+	VectorStoreID string   `json:"vector_store_id"`
+	FileIDs       []string `json:"file_ids"`
+	VideoIDs      []string `json:"video_ids"` // Videos the index was built from
+}
+
+// errCourseChanged is returned when a course is replaced while its transcripts are indexed
+var errCourseChanged = errors.New("course changed while it was indexed")
+
+// courseVideo is the per-video data passed to the course prompt templates
+type courseVideo struct {
+	Position   int
+	VideoID    string
+	Summary    string
+	Transcript string
+}
+
+func courseKey(ctx context.Context, courseID string) string {
+	return tenantKey(ctx, "course", courseID)
+}
+
+func courseSummaryKey(ctx context.Context, language, courseID string) string {
+	return tenantKey(ctx, "course_summary", language+":"+courseID)
+}
+
+func courseIndexKey(ctx context.Context, courseID string) string {
+	return tenantKey(ctx, "course_index", courseID)
+}
+
+func courseAssistantKey(ctx context.Context, userID, courseID string) string {
+	return tenantKey(ctx, "course_assistant", userID+":"+courseID)
+}
+
+// courseDerivedKey holds the set of summary and assistant keys generated from a course
+func courseDerivedKey(ctx context.Context, courseID string) string {
+	return tenantKey(ctx, "course_keys", courseID)
+}
+
+// trackCourseKey records a key generated from a course so that replacing the course drops it
+func trackCourseKey(ctx context.Context, courseID, key string) {
+	if err := RedisClient.SAdd(ctx, courseDerivedKey(ctx, courseID), key).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to track course key", "course_id", courseID, "key", key, "error", err)
+	}
+}
+
+// dropCourseData drops the summaries, assistant sessions and file search index generated from the
+// previous version of a course, and deletes the index's vector store and files
+func dropCourseData(ctx context.Context, courseID string) {
+	index, err := getCourseIndex(ctx, courseID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load course index", "course_id", courseID, "error", err)
+	}
+
+	keys, err := RedisClient.SMembers(ctx, courseDerivedKey(ctx, courseID)).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list course keys", "course_id", courseID, "error", err)
+	}
+	keys = append(keys, courseDerivedKey(ctx, courseID), courseIndexKey(ctx, courseID))
+	if err := RedisClient.Del(ctx, keys...).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to drop course data", "course_id", courseID, "error", err)
+		return
+	}
+
+	if index != nil {
+		go deleteCourseIndex(ctx, index)
+	}
+}
+
+// SaveCourse creates or replaces a course in the caller's tenant, owned by the caller's user.
+// Only the owner, or a service, may replace a course; replacing it drops the summaries, sessions
+// and file search index built from the old course, so they are rebuilt from the new one.
+func SaveCourse(ctx context.Context, course *Course) error {
+	caller := CallerFromContext(ctx)
+	course.OwnerID = caller.UserID
+	course.CreatedAt = time.Now().UTC()
+
+	if course.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return fmt.Errorf("failed to generate course ID: %v", err)
+		}
+		course.ID = id
+	} else {
+		existing, err := GetCourse(ctx, course.ID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if !caller.Service && existing.OwnerID != caller.UserID {
+				return fmt.Errorf("%w: %s", ErrCourseForbidden, course.ID)
+			}
+			if caller.Service {
+				course.OwnerID = existing.OwnerID
+			}
+			if err := storeCourse(ctx, course); err != nil {
+				return err
+			}
+			dropCourseData(ctx, course.ID)
+			return nil
+		}
+	}
+
+	// A new course must not replace one created meanwhile
+	data, err := json.Marshal(course)
+	if err != nil {
+		return fmt.Errorf("failed to marshal course: %v", err)
+	}
+	created, err := RedisClient.SetNX(ctx, courseKey(ctx, course.ID), data, 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store course in Redis: %v", err)
+	}
+	if !created {
+		return fmt.Errorf("%w: %s", ErrCourseForbidden, course.ID)
+	}
+	return nil
+}
+
+func storeCourse(ctx context.Context, course *Course) error {
+	data, err := json.Marshal(course)
+	if err != nil {
+		return fmt.Errorf("failed to marshal course: %v", err)
+	}
+	if err := RedisClient.Set(ctx, courseKey(ctx, course.ID), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store course in Redis: %v", err)
+	}
+	return nil
+}
+
+// GetCourse returns a course, or nil if it does not exist
+func GetCourse(ctx context.Context, courseID string) (*Course, error) {
+	val, err := RedisClient.Get(ctx, courseKey(ctx, courseID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving course from Redis: %v", err)
+	}
+
+	var course Course
+	if err := json.Unmarshal([]byte(val), &course); err != nil {
+		return nil, fmt.Errorf("failed to decode course: %v", err)
+	}
+	return &course, nil
+}
+
+// resolveCourseLanguage uses the requested language, or the language of the course's first video
+func resolveCourseLanguage(ctx context.Context, course *Course, requested string) (string, error) {
+	if lang := NormalizeLanguage(requested); lang != "" {
+		return lang, nil
+	}
+	transcript, err := GetTranscriptFromRedis(ctx, course.VideoIDs[0])
+	if err != nil {
+		return "", err
+	}
+	if transcript == "" {
+		return "", fmt.Errorf("%w for video %s", ErrTranscriptNotFound, course.VideoIDs[0])
+	}
+	return ResolveLanguage(ctx, "", course.VideoIDs[0], transcript), nil
+}
+
+// videoSummary returns the cached summary of a video, generating and caching it if needed
+func videoSummary(ctx context.Context, videoID, language, promptVersion string) (string, error) {
+	cached, err := GetSummaryFromRedis(ctx, videoID, language)
+	if err != nil {
+		return "", err
+	}
+	hit := cached != nil && cached.PromptVersion == promptVersion
+	metrics.CacheLookup("summary", hit)
+	if hit {
+		return cached.Summary, nil
+	}
+
+	transcript, _, err := PrepareTranscript(ctx, videoID, language)
+	if err != nil {
+		return "", err
+	}
+	if transcript == "" {
+		return "", fmt.Errorf("%w for video %s", ErrTranscriptNotFound, videoID)
+	}
+
+	summary, err := GenerateSummary(ctx, transcript, language, promptVersion)
+	if err != nil {
+		return "", err
+	}
+	if err := StoreSummaryInRedis(ctx, videoID, language, summary, promptVersion); err != nil {
+		slog.WarnContext(ctx, "Failed to cache summary", "video_id", videoID, "error", err)
+	}
+	return summary, nil
+}
+
+// GenerateCourseSummary summarizes a course from the summaries of its videos, caching the result per language
+func GenerateCourseSummary(ctx context.Context, course *Course, requestedLang, promptVersion string) (*CachedSummary, string, error) {
+	language, err := resolveCourseLanguage(ctx, course, requestedLang)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := courseSummaryKey(ctx, language, course.ID)
+	if val, err := RedisClient.Get(ctx, key).Result(); err == nil {
+		var cached CachedSummary
+		if err := json.Unmarshal([]byte(val), &cached); err == nil && cached.PromptVersion == promptVersion {
+			metrics.CacheLookup("course_summary", true)
+			return &cached, language, nil
+		}
+	} else if err != redis.Nil {
+		return nil, "", fmt.Errorf("error retrieving course summary from Redis: %v", err)
+	}
+	metrics.CacheLookup("course_summary", false)
+
+	videos := make([]courseVideo, 0, len(course.VideoIDs))
+	for i, videoID := range course.VideoIDs {
+		summary, err := videoSummary(ctx, videoID, language, promptVersion)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to summarize video %s: %v", videoID, err)
+		}
+		videos = append(videos, courseVideo{Position: i + 1, VideoID: videoID, Summary: summary})
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.SummarySystem, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.CourseSummary, map[string]interface{}{
+		"Title":    course.Title,
+		"Language": LanguageName(language),
+		"Videos":   videos,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	summary, err := CallGPT(ctx, prompt, systemPrompt, 0.7, 16000)
+	if err != nil {
+		return nil, "", fmt.Errorf("GPT call failed: %v", err)
+	}
+
+	result := &CachedSummary{Summary: summary, PromptVersion: promptVersion}
+	if data, err := json.Marshal(result); err == nil {
+		if err := RedisClient.Set(ctx, key, data, 168*time.Hour).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to cache course summary", "course_id", course.ID, "error", err)
+		} else {
+			trackCourseKey(ctx, course.ID, key)
+		}
+	}
+	return result, language, nil
+}
+
+// GenerateCourseQuiz generates a cumulative quiz across all videos of a course
+func GenerateCourseQuiz(ctx context.Context, course *Course, requestedLang, promptVersion string) (map[string]interface{}, error) {
+	language, err := resolveCourseLanguage(ctx, course, requestedLang)
+	if err != nil {
+		return nil, err
+	}
+
+	perVideo := courseQuizTranscriptBudget / len(course.VideoIDs)
+	videos := make([]courseVideo, 0, len(course.VideoIDs))
+	for i, videoID := range course.VideoIDs {
+		transcript, _, err := PrepareTranscript(ctx, videoID, language)
+		if err != nil {
+			return nil, err
+		}
+		if transcript == "" {
+			return nil, fmt.Errorf("%w for video %s", ErrTranscriptNotFound, videoID)
+		}
+		if len(transcript) > perVideo {
+			transcript = strings.ToValidUTF8(transcript[:perVideo], "") // Do not split a multi-byte character
+		}
+		videos = append(videos, courseVideo{Position: i + 1, VideoID: videoID, Transcript: transcript})
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.QuizSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.CourseQuiz, map[string]interface{}{
+		"Title":    course.Title,
+		"Language": LanguageName(language),
+		"Videos":   videos,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := CallGPT2(ctx, prompt, systemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("GPT call failed: %v", err)
+	}
+	return response, nil
+}
+
+// getCourseIndex returns the file search index of a course, or nil if it has none
+func getCourseIndex(ctx context.Context, courseID string) (*courseIndex, error) {
+	val, err := RedisClient.Get(ctx, courseIndexKey(ctx, courseID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving course index from Redis: %v", err)
+	}
+
+	var index courseIndex
+	if err := json.Unmarshal([]byte(val), &index); err != nil {
+		return nil, fmt.Errorf("failed to decode course index: %v", err)
+	}
+	return &index, nil
+}
+
+// deleteCourseIndex deletes a vector store and its files. It runs after the request may have been
+// cancelled, so it does not inherit the request's cancellation.
+func deleteCourseIndex(ctx context.Context, index *courseIndex) {
+	ctx = context.WithoutCancel(ctx)
+	if err := callOpenAI(ctx, "DELETE", openAIBaseURL+"/vector_stores/"+index.VectorStoreID, nil, nil); err != nil {
+		slog.WarnContext(ctx, "Failed to delete vector store", "vector_store_id", index.VectorStoreID, "error", err)
+	}
+	for _, fileID := range index.FileIDs {
+		if err := DeleteFile(ctx, fileID); err != nil {
+			slog.WarnContext(ctx, "Failed to delete course transcript", "file_id", fileID, "error", err)
+		}
+	}
+}
+
+// ensureCourseVectorStore uploads the course transcripts and indexes them in a vector store for file search
+func ensureCourseVectorStore(ctx context.Context, course *Course) (string, error) {
+	existing, err := getCourseIndex(ctx, course.ID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil && slices.Equal(existing.VideoIDs, course.VideoIDs) {
+		return existing.VectorStoreID, nil
+	}
+
+	index := &courseIndex{VideoIDs: course.VideoIDs}
+	for i, videoID := range course.VideoIDs {
+		transcript, err := GetSourceTranscript(ctx, videoID)
+		if err != nil {
+			return "", err
+		}
+		if transcript == "" {
+			return "", fmt.Errorf("%w for video %s", ErrTranscriptNotFound, videoID)
+		}
+
+		// File search returns excerpts of these files to the assistant, so mark them as material rather than instructions
+		content := fmt.Sprintf("Course:\n%s\nVideo %d (%s)\nTranscript (seconds: text):\n%s",
+			prompts.Untrusted("course title", course.Title), i+1, videoID, prompts.Untrusted("transcript", transcript))
+		fileID, err := UploadFile(ctx, "assistants", fmt.Sprintf("course_%s_%02d_%s.txt", course.ID, i+1, videoID), []byte(content))
+		if err != nil {
+			return "", fmt.Errorf("failed to upload transcript for video %s: %v", videoID, err)
+		}
+		index.FileIDs = append(index.FileIDs, fileID)
+	}
+
+	var storeResp struct {
+		ID string `json:"id"`
+	}
+	err = callOpenAI(ctx, "POST", openAIBaseURL+"/vector_stores", map[string]interface{}{
+		"name":     "course_" + course.ID,
+		"file_ids": index.FileIDs,
+	}, &storeResp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create vector store: %v", err)
+	}
+	index.VectorStoreID = storeResp.ID
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal course index: %v", err)
+	}
+
+	// Record the index only if the course still has the videos it was built from. A session that
+	// indexed the same videos concurrently wins, and this index is deleted.
+	vectorStoreID := index.VectorStoreID
+	err = RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := GetCourse(ctx, course.ID)
+		if err != nil {
+			return err
+		}
+		if current == nil || !slices.Equal(current.VideoIDs, index.VideoIDs) {
+			return errCourseChanged
+		}
+		recorded, err := getCourseIndex(ctx, course.ID)
+		if err != nil {
+			return err
+		}
+		if recorded != nil && slices.Equal(recorded.VideoIDs, index.VideoIDs) {
+			vectorStoreID = recorded.VectorStoreID
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, courseIndexKey(ctx, course.ID), data, 0)
+			return nil
+		})
+		return err
+	}, courseKey(ctx, course.ID), courseIndexKey(ctx, course.ID))
+	if errors.Is(err, redis.TxFailedErr) {
+		err = errCourseChanged
+	}
+	if err != nil || vectorStoreID != index.VectorStoreID {
+		go deleteCourseIndex(ctx, index)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to store course index: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Indexed course transcripts", "course_id", course.ID, "transcripts", len(index.FileIDs), "vector_store_id", vectorStoreID)
+	return vectorStoreID, nil
+}
+
+// CreateCourseAssistant creates an assistant whose context spans every transcript of the course
+// through file search, and records it for the user.
+func CreateCourseAssistant(ctx context.Context, course *Course, userID, persona, promptVersion string) (string, error) {
+	vectorStoreID, err := ensureCourseVectorStore(ctx, course)
+	if err != nil {
+		return "", err
+	}
+
+	videos := make([]courseVideo, 0, len(course.VideoIDs))
+	for i, videoID := range course.VideoIDs {
+		videos = append(videos, courseVideo{Position: i + 1, VideoID: videoID})
+	}
+	instructions, err := prompts.Render(promptVersion, prompts.CourseAssistantInstructions, map[string]interface{}{
+		"Title":  course.Title,
+		"Videos": videos,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Persona and guardrails layer on top exactly as for single-video sessions
+	if persona != "" {
+		personaInstructions, err := prompts.Render(promptVersion, prompts.Persona(persona), nil)
+		if err != nil {
+			return "", err
+		}
+		instructions += "\n\n" + personaInstructions
+	}
+	guardrails, err := prompts.Render(promptVersion, prompts.Guardrails, nil)
+	if err != nil {
+		return "", err
+	}
+	instructions += "\n\n" + guardrails
+
+	var createResp struct {
+		ID string `json:"id"`
+	}
+	err = callOpenAI(ctx, "POST", openAIBaseURL+"/assistants", map[string]interface{}{
+		"model":        "gpt-4o-mini",
+		"name":         "course_" + course.ID,
+		"instructions": instructions,
+		"tools":        []map[string]string{{"type": "file_search"}},
+		"tool_resources": map[string]interface{}{
+			"file_search": map[string]interface{}{"vector_store_ids": []string{vectorStoreID}},
+		},
+		"metadata": map[string]string{"course_id": course.ID, "prompt_version": promptVersion, "persona": persona},
+	}, &createResp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create assistant: %v", err)
+	}
+
+	if err := RedisClient.Set(ctx, courseAssistantKey(ctx, userID, course.ID), createResp.ID, 168*time.Hour).Err(); err != nil {
+		return "", fmt.Errorf("failed to store course assistant in Redis: %v", err)
+	}
+	trackCourseKey(ctx, course.ID, courseAssistantKey(ctx, userID, course.ID))
+	if err := RedisClient.Set(ctx, assistantPromptVersionKey(createResp.ID), promptVersion, 168*time.Hour).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to store prompt version", "assistant_id", createResp.ID, "error", err)
+	}
+
+	slog.InfoContext(ctx, "Created course assistant", "assistant_id", createResp.ID, "course_id", course.ID)
+	return createResp.ID, nil
+}
+
+// GetCourseAssistantID returns the user's assistant for a course
+func GetCourseAssistantID(ctx context.Context, userID, courseID string) (string, error) {
+	assistantID, err := RedisClient.Get(ctx, courseAssistantKey(ctx, userID, courseID)).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("course %w for user %s and course %s", ErrSessionNotFound, userID, courseID)
+	} else if err != nil {
+		return "", fmt.Errorf("redis error: %v", err)
+	}
+	return assistantID, nil
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const (
+	embeddingModel = "text-embedding-3-small"
+	// Length in seconds of the transcript windows that are embedded
+	embeddingChunkSeconds = 60
+	// Inputs sent per embeddings request
+	embeddingBatchSize = 100
+	// Redis hash of the binary codes of every indexed video's chunks, by video ID
+	embeddingCodesKey = "embedding_codes"
+	// Videos whose codes come closest to the query are re-ranked with their full vectors, this many per result
+	relatedCandidateFactor = 4
+	// Maximum characters of transcript returned with each related segment
+	relatedSnippetLength = 300
+)
+
+// TranscriptChunk is an embedded window of a transcript. Vectors are normalized to unit length,
+// so their dot product is their cosine similarity.
+type TranscriptChunk struct {
+	Start  float64   `json:"start"`
+	End    float64   `json:"end"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"-"`
+}
+
+// RelatedSegment is a place in another video that discusses the same concept
+type RelatedSegment struct {
+	VideoID string  `json:"video_id"`
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// embeddingsKey is a hash of a video's chunks: "chunks" holds their JSON without vectors and
+// "vectors" the vectors packed by packVectors
+func embeddingsKey(videoID string) string {
+	return fmt.Sprintf("embeddings:%s", videoID)
+}
+
+// CreateEmbeddings embeds each input with the embeddings model, preserving order
+func CreateEmbeddings(ctx context.Context, inputs []string) ([][]float32, error) {
+	if err := CheckBudget(ctx); err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		var embeddingResp struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		err := callOpenAIWithTimeout(ctx, "POST", openAIBaseURL+"/embeddings", map[string]interface{}{
+			"model": embeddingModel,
+			"input": inputs[start:end],
+		}, &embeddingResp, openAICompletionTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embeddings: %v", err)
+		}
+		if len(embeddingResp.Data) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(embeddingResp.Data))
+		}
+
+		sort.Slice(embeddingResp.Data, func(i, j int) bool { return embeddingResp.Data[i].Index < embeddingResp.Data[j].Index })
+		for _, d := range embeddingResp.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	return vectors, nil
+}
+
+// chunkSegments groups transcript segments into windows of embeddingChunkSeconds. A window ends
+// where its last segment ends.
+func chunkSegments(segments []TranscriptSegment) []TranscriptChunk {
+	var chunks []TranscriptChunk
+	var current *TranscriptChunk
+	var texts []string
+
+	flush := func() {
+		if current != nil && len(texts) > 0 {
+			current.Text = strings.Join(texts, " ")
+			chunks = append(chunks, *current)
+		}
+		current, texts = nil, nil
+	}
+
+	for _, segment := range segments {
+		if current != nil && segment.Start-current.Start >= embeddingChunkSeconds {
+			flush()
+		}
+		if current == nil {
+			current = &TranscriptChunk{Start: segment.Start}
+		}
+		if end := segment.Start + segment.Duration; end > current.End {
+			current.End = end
+		}
+		texts = append(texts, segment.Text)
+	}
+	flush()
+	return chunks
+}
+
+// normalizeVector scales a vector to unit length in place
+func normalizeVector(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+// packVectors concatenates vectors as little-endian float32s, a fraction of the size of their JSON
+func packVectors(vectors [][]float32) []byte {
+	var dims int
+	if len(vectors) > 0 {
+		dims = len(vectors[0])
+	}
+	packed := make([]byte, 0, len(vectors)*dims*4)
+	for _, v := range vectors {
+		for _, x := range v {
+			packed = binary.LittleEndian.AppendUint32(packed, math.Float32bits(x))
+		}
+	}
+	return packed
+}
+
+// unpackVectors splits packed vectors back into n vectors
+func unpackVectors(packed []byte, n int) ([][]float32, error) {
+	if n == 0 || len(packed)%(4*n) != 0 {
+		return nil, fmt.Errorf("packed vectors do not hold %d vectors", n)
+	}
+	dims := len(packed) / 4 / n
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dims)
+		for j := range vectors[i] {
+			offset := (i*dims + j) * 4
+			vectors[i][j] = math.Float32frombits(binary.LittleEndian.Uint32(packed[offset:]))
+		}
+	}
+	return vectors, nil
+}
+
+// signCode keeps one bit per dimension, set where the vector is positive. The Hamming distance of
+// two codes tracks the angle between their vectors, so codes pre-filter candidates at 1/32 of the size.
+func signCode(v []float32) []byte {
+	code := make([]byte, (len(v)+7)/8)
+	for i, x := range v {
+		if x > 0 {
+			code[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return code
+}
+
+// minCodeDistance returns the smallest Hamming distance between the query code and the codes of a
+// video's chunks, concatenated
+func minCodeDistance(query, codes []byte) int {
+	size := len(query)
+	best := -1
+	for offset := 0; size > 0 && offset+size <= len(codes); offset += size {
+		distance := 0
+		for i := 0; i < size; i++ {
+			distance += bits.OnesCount8(query[i] ^ codes[offset+i])
+		}
+		if best == -1 || distance < best {
+			best = distance
+		}
+	}
+	return best
+}
+
+// IndexTranscriptEmbeddings embeds a video's transcript in windows and stores the vectors in Redis
+func IndexTranscriptEmbeddings(ctx context.Context, videoID string) (int, error) {
+	segments, err := transcriptSegmentsForVideo(ctx, videoID)
+	if err != nil {
+		return 0, err
+	}
+	if len(segments) == 0 {
+		return 0, fmt.Errorf("%w for video %s", ErrTranscriptNotFound, videoID)
+	}
+
+	chunks := chunkSegments(segments)
+	if len(chunks) == 0 {
+		return 0, fmt.Errorf("transcript for video %s has no timestamped lines", videoID)
+	}
+
+	inputs := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		inputs[i] = chunk.Text
+	}
+	vectors, err := CreateEmbeddings(ctx, inputs)
+	if err != nil {
+		return 0, err
+	}
+	var codes []byte
+	for i := range chunks {
+		normalizeVector(vectors[i])
+		chunks[i].Vector = vectors[i]
+		codes = append(codes, signCode(vectors[i])...)
+	}
+
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal embeddings: %v", err)
+	}
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(ctx, embeddingsKey(videoID))
+	pipe.HSet(ctx, embeddingsKey(videoID), "chunks", data, "vectors", packVectors(vectors))
+	pipe.HSet(ctx, embeddingCodesKey, videoID, codes)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to store embeddings in Redis: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Indexed transcript chunks", "video_id", videoID, "chunks", len(chunks))
+	return len(chunks), nil
+}
+
+// GetTranscriptEmbeddings returns the stored chunks of a video, or nil if it has not been indexed
+func GetTranscriptEmbeddings(ctx context.Context, videoID string) ([]TranscriptChunk, error) {
+	values, err := RedisClient.HMGet(ctx, embeddingsKey(videoID), "chunks", "vectors").Result()
+	if err != nil {
+		// Videos indexed before vectors were packed are stored as a JSON string; they are re-indexed
+		if strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error retrieving embeddings from Redis: %v", err)
+	}
+	data, ok := values[0].(string)
+	packed, ok2 := values[1].(string)
+	if !ok || !ok2 {
+		return nil, nil
+	}
+
+	var chunks []TranscriptChunk
+	if err := json.Unmarshal([]byte(data), &chunks); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %v", err)
+	}
+	vectors, err := unpackVectors([]byte(packed), len(chunks))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %v", err)
+	}
+	for i := range chunks {
+		chunks[i].Vector = vectors[i]
+	}
+	return chunks, nil
+}
+
+func dotProduct(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+// relatedCandidates returns the videos, other than the source, whose chunk codes come closest to
+// the query code, best first. Codes are scanned in batches so no single reply holds the whole index.
+func relatedCandidates(ctx context.Context, videoID string, queryCode []byte, n int) ([]string, error) {
+	type candidate struct {
+		videoID  string
+		distance int
+	}
+	var candidates []candidate
+
+	iter := RedisClient.HScan(ctx, embeddingCodesKey, 0, "", 200).Iterator()
+	for iter.Next(ctx) {
+		otherID := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		if otherID == videoID {
+			continue
+		}
+		if distance := minCodeDistance(queryCode, []byte(iter.Val())); distance >= 0 {
+			candidates = append(candidates, candidate{otherID, distance})
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning embedding codes in Redis: %v", err)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	videoIDs := make([]string, len(candidates))
+	for i, c := range candidates {
+		videoIDs[i] = c.videoID
+	}
+	return videoIDs, nil
+}
+
+// FindRelatedSegments returns the best matching segment in each other indexed video for a question,
+// or for the part of the source video around the timestamp when no question is given, in which case
+// the source video must have been indexed.
+func FindRelatedSegments(ctx context.Context, videoID string, timestamp int, question string, limit int) ([]RelatedSegment, error) {
+	var queryVector []float32
+	if question != "" {
+		vectors, err := CreateEmbeddings(ctx, []string{question})
+		if err != nil {
+			return nil, err
+		}
+		queryVector = vectors[0]
+	} else {
+		chunks, err := GetTranscriptEmbeddings(ctx, videoID)
+		if err != nil {
+			return nil, err
+		}
+		// Indexing is paid and left to services, so an unindexed video is not indexed on the fly
+		if chunks == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotIndexed, videoID)
+		}
+
+		// Use the chunk covering the timestamp
+		for _, chunk := range chunks {
+			if chunk.Start > float64(timestamp) {
+				break
+			}
+			queryVector = chunk.Vector
+		}
+		if queryVector == nil && len(chunks) > 0 {
+			queryVector = chunks[0].Vector
+		}
+	}
+	if queryVector == nil {
+		return nil, fmt.Errorf("no query to search with")
+	}
+	normalizeVector(queryVector)
+
+	// Pre-filter on the compact codes, then score the candidates exactly with their full vectors
+	candidates, err := relatedCandidates(ctx, videoID, signCode(queryVector), limit*relatedCandidateFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	var related []RelatedSegment
+	for _, otherID := range candidates {
+		chunks, err := GetTranscriptEmbeddings(ctx, otherID)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping embeddings", "video_id", otherID, "error", err)
+			continue
+		}
+
+		var best *RelatedSegment
+		for _, chunk := range chunks {
+			score := dotProduct(queryVector, chunk.Vector)
+			if best == nil || score > best.Score {
+				best = &RelatedSegment{VideoID: otherID, Start: chunk.Start, End: chunk.End, Snippet: chunk.Text, Score: score}
+			}
+		}
+		if best != nil {
+			if len(best.Snippet) > relatedSnippetLength {
+				best.Snippet = strings.ToValidUTF8(best.Snippet[:relatedSnippetLength], "") + "…"
+			}
+			related = append(related, *best)
+		}
+	}
+
+	sort.Slice(related, func(i, j int) bool { return related[i].Score > related[j].Score })
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
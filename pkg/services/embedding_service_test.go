@@ -0,0 +1,110 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []TranscriptSegment
+		want     []TranscriptChunk
+	}{
+		{
+			name: "chunk ends where its last segment ends",
+			segments: []TranscriptSegment{
+				{Start: 0, Duration: 5, Text: "one"},
+				{Start: 30, Duration: 4, Text: "two"},
+				{Start: 60, Duration: 3, Text: "three"},
+			},
+			want: []TranscriptChunk{
+				{Start: 0, End: 34, Text: "one two"},
+				{Start: 60, End: 63, Text: "three"},
+			},
+		},
+		{
+			name: "gaps do not stretch a chunk",
+			segments: []TranscriptSegment{
+				{Start: 0, Duration: 2, Text: "intro"},
+				{Start: 125, Duration: 5, Text: "later"},
+			},
+			want: []TranscriptChunk{
+				{Start: 0, End: 2, Text: "intro"},
+				{Start: 125, End: 130, Text: "later"},
+			},
+		},
+		{
+			name: "overlapping rolling captions keep the latest end",
+			segments: []TranscriptSegment{
+				{Start: 0, Duration: 10, Text: "long"},
+				{Start: 2, Duration: 3, Text: "short"},
+			},
+			want: []TranscriptChunk{{Start: 0, End: 10, Text: "long short"}},
+		},
+		{
+			name: "no segments",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkSegments(tt.segments); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkSegments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackVectors(t *testing.T) {
+	vectors := [][]float32{{0.5, -1, 0}, {1e-7, 3.25, -0.125}}
+	packed := packVectors(vectors)
+	if len(packed) != 2*3*4 {
+		t.Fatalf("packVectors() = %d bytes, want %d", len(packed), 2*3*4)
+	}
+
+	got, err := unpackVectors(packed, len(vectors))
+	if err != nil {
+		t.Fatalf("unpackVectors() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, vectors) {
+		t.Errorf("unpackVectors() = %v, want %v", got, vectors)
+	}
+
+	if _, err := unpackVectors(packed, 5); err == nil {
+		t.Error("unpackVectors() accepted a count that does not divide the data")
+	}
+	if _, err := unpackVectors(packed, 0); err == nil {
+		t.Error("unpackVectors() accepted zero vectors")
+	}
+}
+
+func TestMinCodeDistance(t *testing.T) {
+	query := signCode([]float32{1, -1, 1, -1, 1, -1, 1, -1, 1})
+	tests := []struct {
+		name  string
+		codes [][]float32
+		want  int
+	}{
+		{name: "same signs", codes: [][]float32{{2, -3, 4, -5, 6, -7, 8, -9, 1}}, want: 0},
+		{name: "all signs flipped", codes: [][]float32{{-1, 1, -1, 1, -1, 1, -1, 1, -1}}, want: 9},
+		{name: "closest chunk wins", codes: [][]float32{{-1, 1, -1, 1, -1, 1, -1, 1, -1}, {1, -1, 1, -1, 1, -1, 1, 1, -1}}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var codes []byte
+			for _, v := range tt.codes {
+				codes = append(codes, signCode(v)...)
+			}
+			if got := minCodeDistance(query, codes); got != tt.want {
+				t.Errorf("minCodeDistance() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := minCodeDistance(query, nil); got != -1 {
+		t.Errorf("minCodeDistance() with no codes = %d, want -1", got)
+	}
+}
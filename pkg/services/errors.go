@@ -0,0 +1,15 @@
+package services
+
+import "errors"
+
+// Errors handlers map to stable API error codes. Services wrap them with %w to add detail.
+var (
+	ErrTranscriptNotFound = errors.New("transcript not found")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrUnknownPersona     = errors.New("unknown persona")
+	ErrBudgetExceeded     = errors.New("monthly usage budget exceeded")
+	ErrContentFlagged     = errors.New("content flagged")
+	ErrCourseForbidden    = errors.New("course belongs to another user")
+	ErrInvalidFrame       = errors.New("invalid frame")
+	ErrNotIndexed         = errors.New("video embeddings not indexed")
+)
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GlossaryTerm is a technical term extracted from a transcript
+type GlossaryTerm struct {
+	Term           string   `json:"term"`
+	Definition     string   `json:"definition"`
+	FirstTimestamp float64  `json:"first_timestamp"`
+	RelatedTerms   []string `json:"related_terms"`
+}
+
+// Glossary is the cached glossary of a video in one language
+type Glossary struct {
+	Terms         []GlossaryTerm `json:"terms"`
+	Language      string         `json:"language"`
+	PromptVersion string         `json:"prompt_version"`
+}
+
+var glossarySchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"terms": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"term":            map[string]interface{}{"type": "string"},
+					"definition":      map[string]interface{}{"type": "string"},
+					"first_timestamp": map[string]interface{}{"type": "number"},
+					"related_terms": map[string]interface{}{
+						"type":  "array",
+						"items": map[string]interface{}{"type": "string"},
+					},
+				},
+				"required":             []string{"term", "definition", "first_timestamp", "related_terms"},
+				"additionalProperties": false,
+			},
+		},
+	},
+	"required":             []string{"terms"},
+	"additionalProperties": false,
+}
+
+func glossaryKey(ctx context.Context, language, videoID string) string {
+	return tenantKey(ctx, "glossary", language+":"+videoID)
+}
+
+// GenerateGlossary extracts the key terms of a transcript with definitions and where they are first introduced
+func GenerateGlossary(ctx context.Context, transcript, language, promptVersion string) (*Glossary, error) {
+	if transcript == "" {
+		return nil, fmt.Errorf("transcript is empty")
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.GlossarySystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.Glossary, map[string]interface{}{
+		"Transcript": transcript,
+		"Language":   LanguageName(language),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := CallGPTJSON(ctx, prompt, systemPrompt, "glossary", glossarySchema, 0.3, 10000)
+	if err != nil {
+		return nil, fmt.Errorf("GPT call failed: %v", err)
+	}
+
+	glossary := &Glossary{Language: language, PromptVersion: promptVersion}
+	if err := json.Unmarshal([]byte(response), glossary); err != nil {
+		return nil, fmt.Errorf("failed to parse glossary: %v", err)
+	}
+	return glossary, nil
+}
+
+func StoreGlossaryInRedis(ctx context.Context, videoID string, glossary *Glossary) error {
+	data, err := json.Marshal(glossary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal glossary: %v", err)
+	}
+	return storeDerived(ctx, videoID, glossaryKey(ctx, glossary.Language, videoID), data, 168*time.Hour)
+}
+
+// GetGlossaryFromRedis returns the cached glossary for a video and language, or nil if there is none
+func GetGlossaryFromRedis(ctx context.Context, videoID, language string) (*Glossary, error) {
+	val, err := RedisClient.Get(ctx, glossaryKey(ctx, language, videoID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving glossary from Redis: %v", err)
+	}
+
+	var glossary Glossary
+	if err := json.Unmarshal([]byte(val), &glossary); err != nil {
+		return nil, fmt.Errorf("failed to decode glossary: %v", err)
+	}
+	return &glossary, nil
+}
+
+// GlossaryContext returns run instructions with the glossary entries mentioned in a question,
+// so the assistant uses the video's own definitions when a learner asks what a term means.
+func GlossaryContext(ctx context.Context, videoID, question, language, promptVersion string) string {
+	// Prefer the answer language, fall back to the transcript's own language
+	candidates := []string{language}
+	if lang, err := RedisClient.Get(ctx, "transcript_language:"+videoID).Result(); err == nil {
+		candidates = append(candidates, lang)
+	}
+
+	var glossary *Glossary
+	for _, lang := range candidates {
+		if lang == "" {
+			continue
+		}
+		g, err := GetGlossaryFromRedis(ctx, videoID, lang)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to load glossary", "video_id", videoID, "error", err)
+			return ""
+		}
+		if g != nil {
+			glossary = g
+			break
+		}
+	}
+	if glossary == nil {
+		return ""
+	}
+
+	lowerQuestion := strings.ToLower(question)
+	var matched []GlossaryTerm
+	for _, term := range glossary.Terms {
+		if mentionsTerm(lowerQuestion, strings.ToLower(term.Term)) {
+			matched = append(matched, term)
+		}
+	}
+	if len(matched) == 0 {
+		return ""
+	}
+
+	reference, err := prompts.Render(promptVersion, prompts.GlossaryReference, map[string]interface{}{"Terms": matched})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to render glossary reference", "video_id", videoID, "error", err)
+		return ""
+	}
+	return reference
+}
+
+// mentionsTerm reports whether lowercase text contains a lowercase term as a whole word, so short
+// terms such as "go" or "ai" do not match inside "good" or "explain"
+func mentionsTerm(text, term string) bool {
+	if term == "" {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], term)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(term)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		offset = start + size
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
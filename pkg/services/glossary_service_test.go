@@ -0,0 +1,32 @@
+package services
+
+import "testing"
+
+func TestMentionsTerm(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		term string
+		want bool
+	}{
+		{name: "whole word", text: "what is a closure here?", term: "closure", want: true},
+		{name: "at the start", text: "go routines confuse me", term: "go", want: true},
+		{name: "at the end", text: "why use ai", term: "ai", want: true},
+		{name: "inside a word", text: "can you explain this", term: "ai", want: false},
+		{name: "prefix of a word", text: "is this good", term: "go", want: false},
+		{name: "later whole word after a partial match", text: "a good go program", term: "go", want: true},
+		{name: "multi-word term", text: "how does gradient descent converge?", term: "gradient descent", want: true},
+		{name: "term ending in punctuation", text: "is c++ faster?", term: "c++", want: true},
+		{name: "non-latin text", text: "что такое замыкание?", term: "замыкание", want: true},
+		{name: "non-latin inside a word", text: "замыкания", term: "замыкание", want: false},
+		{name: "empty term", text: "anything", term: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mentionsTerm(tt.text, tt.term); got != tt.want {
+				t.Errorf("mentionsTerm(%q, %q) = %v, want %v", tt.text, tt.term, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,13 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
+	"Learning-Mode-AI-Ai-Service/pkg/logging"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
+	"log/slog"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,104 +28,163 @@ type InitializeRequest struct {
 	Title              string `json:"title"`
 	Channel            string `json:"channel"`
 	Transcript         string `json:"transcript"`
+	PromptVersion      string `json:"prompt_version,omitempty"`
+	Persona            string `json:"persona,omitempty"`   // tutor, socratic, eli5 or exam_prep
+	TenantID           string `json:"tenant_id,omitempty"` // Selects the tenant's default persona
+}
+
+// QuestionOptions carries the optional settings of an ask-question request
+type QuestionOptions struct {
+	Frame         []byte // Video frame at the question's timestamp
+	Language      string // ISO 639-1 code for the answer
+	PromptVersion string
+	Persona       string // Overrides the assistant's persona for this answer
 }
 
 // CreateAssistantWithMetadata creates a new assistant based on YouTube video metadata
-func CreateAssistantWithMetadata(initReq InitializeRequest) (string, error) {
-	url := "https://api.openai.com/v1/assistants"
+func CreateAssistantWithMetadata(ctx context.Context, initReq InitializeRequest) (string, error) {
+	url := openAIBaseURL + "/assistants"
+
+	// Include any on-screen content already extracted for this video
+	visualTranscript, err := GetVisualTranscriptFromRedis(ctx, initReq.VideoID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load visual transcript", "video_id", initReq.VideoID, "error", err)
+	}
+	// Prefer the cleaned transcript when this service has the video's transcript
+	transcript := initReq.Transcript
+	if source, err := GetSourceTranscript(ctx, initReq.VideoID); err != nil {
+		slog.WarnContext(ctx, "Failed to load source transcript", "video_id", initReq.VideoID, "error", err)
+	} else if source != "" {
+		transcript = source
+	}
+	transcript = CombineTranscripts(transcript, visualTranscript)
+	ScanTranscript(ctx, initReq.VideoID, transcript)
+
+	persona, err := ResolvePersona(initReq.Persona, initReq.TenantID)
+	if err != nil {
+		return "", err
+	}
+
+	promptVersion := prompts.ResolveVersion(initReq.PromptVersion)
+	instructions, err := BuildAssistantInstructions(promptVersion, initReq, transcript, persona)
+	if err != nil {
+		return "", err
+	}
 
 	requestBody := map[string]interface{}{
 		"model":        "gpt-4o-mini",
 		"name":         initReq.VideoID,
-		"instructions": fmt.Sprintf("You are a helpful assistant for the video titled '%s' by '%s'. Here is the transcript: %s", initReq.Title, initReq.Channel, initReq.Transcript),
+		"instructions": instructions,
+		"metadata":     map[string]string{"video_id": initReq.VideoID, "prompt_version": promptVersion, "persona": persona},
 	}
 
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
+	var createResp struct {
+		ID string `json:"id"`
+	}
+	if err := callOpenAI(ctx, "POST", url, requestBody, &createResp); err != nil {
+		return "", fmt.Errorf("failed to create assistant: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	// Record which prompt version the assistant was built with
+	err = RedisClient.Set(ctx, assistantPromptVersionKey(createResp.ID), promptVersion, 168*time.Hour).Err()
 	if err != nil {
-		return "", fmt.Errorf("failed to create HTTP request: %v", err)
+		slog.WarnContext(ctx, "Failed to store prompt version", "assistant_id", createResp.ID, "error", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
+	return createResp.ID, nil
+}
+
+func assistantPromptVersionKey(assistantID string) string {
+	return fmt.Sprintf("assistant_prompt_version:%s", assistantID)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// GetAssistantPromptVersion returns the prompt version an assistant was created with, or "" if unknown
+func GetAssistantPromptVersion(ctx context.Context, assistantID string) string {
+	version, err := RedisClient.Get(ctx, assistantPromptVersionKey(assistantID)).Result()
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return ""
 	}
-	defer resp.Body.Close()
+	return version
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to create assistant: %s", string(bodyBytes))
+// AskAssistantQuestion adds a question to the thread and gets a response.
+// If a frame is supplied it is attached to the question as image content.
+func AskAssistantQuestion(ctx context.Context, videoID, assistantID, question string, timestamp int, opts QuestionOptions) (string, error) {
+	prompt, err := createPrompt(opts.PromptVersion, question, timestamp, len(opts.Frame) > 0, opts.Language)
+	if err != nil {
+		return "", err
 	}
 
-	var createResp struct {
-		ID string `json:"id"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&createResp)
+	// Switch persona for this answer only if one was requested
+	additionalInstructions, err := BuildPersonaInstructions(opts.PromptVersion, opts.Persona)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+		return "", err
 	}
 
-	return createResp.ID, nil
+	// Point the assistant at the glossary definitions of any terms the learner asks about
+	if glossary := GlossaryContext(ctx, videoID, question, opts.Language, opts.PromptVersion); glossary != "" {
+		additionalInstructions = strings.TrimSpace(additionalInstructions + "\n\n" + glossary)
+	}
+
+	return askThread(ctx, videoID, assistantID, prompt, timestamp, opts.Frame, additionalInstructions)
 }
 
-// AskAssistantQuestion adds a question to the thread and gets a response
-func AskAssistantQuestion(videoID, assistantID, question string, timestamp int) (string, error) {
-	threadManager, err := GetOrCreateThreadManager(assistantID)
+// askThread posts a prompt (and optional frame) to the assistant's thread and runs the assistant on it
+func askThread(ctx context.Context, videoID, assistantID, prompt string, timestamp int, frame []byte, additionalInstructions string) (string, error) {
+	threadManager, err := GetOrCreateThreadManager(ctx, assistantID)
 	if err != nil {
 		return "", fmt.Errorf("failed to get thread manager: %v", err)
 	}
 
-	// Pass the timestamp to AddMessageToThread
-	err = threadManager.AddMessageToThread("user", question, assistantID, timestamp)
+	imageFileID := ""
+	if len(frame) > 0 {
+		imageFileID, err = UploadFrame(ctx, frame, videoID, timestamp)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload frame: %v", err)
+		}
+		// The frame is only needed for this run
+		defer DeleteFrame(ctx, imageFileID)
+	}
+
+	err = threadManager.AddMessageToThread(ctx, "user", prompt, assistantID, imageFileID)
 	if err != nil {
 		return "", fmt.Errorf("failed to add message: %v", err)
 	}
 
-	// Run the assistant as usual
-	return threadManager.RunAssistant(assistantID)
+	return threadManager.RunAssistant(ctx, assistantID, additionalInstructions)
 }
 
 // GetOrCreateThreadManager retrieves the thread from Redis or creates a new one if it doesn't exist
-func GetOrCreateThreadManager(assistantID string) (*ThreadManager, error) {
+func GetOrCreateThreadManager(ctx context.Context, assistantID string) (*ThreadManager, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	// Generate Redis key using assistantID
 	redisKey := fmt.Sprintf("thread_id:%s", assistantID)
 
-	log.Printf("🔎 Checking Redis for thread ID: %s", redisKey)
+	slog.DebugContext(ctx, "Looking up thread", "assistant_id", assistantID)
 
 	// Check if a thread ID already exists in Redis
-	threadID, err := RedisClient.Get(Ctx, redisKey).Result()
+	threadID, err := RedisClient.Get(ctx, redisKey).Result()
 	if err != nil {
-		log.Println("❌ No thread found for Assistant:", assistantID)
-		log.Println("🔵 Attempting to create a new thread...")
+		slog.DebugContext(ctx, "No thread found, creating one", "assistant_id", assistantID)
 
 		// 🔹 Create a new thread if none exists
-		threadID, err = createThread()
+		threadID, err = createThread(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create thread: %v", err)
 		}
 
 		// 🔹 Store the new thread ID in Redis
-		err = RedisClient.Set(Ctx, redisKey, threadID, 168*time.Hour).Err()
+		err = RedisClient.Set(ctx, redisKey, threadID, 168*time.Hour).Err()
 		if err != nil {
-			log.Printf("⚠️ Failed to store thread ID in Redis for Assistant: %s, Error: %v", assistantID, err)
+			slog.ErrorContext(ctx, "Failed to store thread ID", "assistant_id", assistantID, "error", err)
 			return nil, fmt.Errorf("failed to store thread ID in Redis: %v", err)
 		}
 
-		log.Printf("✅ Successfully created and stored thread ID: %s for Assistant: %s", threadID, assistantID)
+		slog.InfoContext(ctx, "Created thread", "thread_id", threadID, "assistant_id", assistantID)
 	} else {
-		log.Printf("✅ Found existing thread ID: %s for Assistant: %s", threadID, assistantID)
+		slog.DebugContext(ctx, "Found existing thread", "thread_id", threadID, "assistant_id", assistantID)
 	}
 
 	// Create a ThreadManager instance
@@ -135,167 +193,117 @@ func GetOrCreateThreadManager(assistantID string) (*ThreadManager, error) {
 	return tm, nil
 }
 
-func createThread() (string, error) {
-	url := "https://api.openai.com/v1/threads"
-	requestBody := map[string]interface{}{}
-
-	log.Println("🔵 Creating new thread...") // Debugging log
-
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		log.Printf("❌ Failed to marshal thread creation request: %v", err)
-		return "", fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		log.Printf("❌ Failed to create HTTP request for thread creation: %v", err)
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("❌ Failed to send thread creation request: %v", err)
-		return "", fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("❌ Thread creation failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
-		return "", fmt.Errorf("failed to create thread: %s", string(bodyBytes))
-	}
+func createThread(ctx context.Context) (string, error) {
 
 	var threadResp struct {
 		ID string `json:"id"`
 	}
-	err = json.NewDecoder(resp.Body).Decode(&threadResp)
-	if err != nil {
-		log.Printf("❌ Failed to decode thread creation response: %v", err)
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	if err := callOpenAI(ctx, "POST", openAIBaseURL+"/threads", map[string]interface{}{}, &threadResp); err != nil {
+		slog.ErrorContext(ctx, "Failed to create thread", "error", err)
+		return "", fmt.Errorf("failed to create thread: %w", err)
 	}
 
-	log.Printf("✅ Thread created with ID %s", threadResp.ID)
 	return threadResp.ID, nil
 }
 
 // Storing each interaction message in Redis
-func (tm *ThreadManager) AddMessageToThread(role, content, assistantID string, timestamp int) error {
-	url := fmt.Sprintf("https://api.openai.com/v1/threads/%s/messages", tm.ThreadID)
-
-	prompt := createPrompt(content, timestamp)
-	log.Printf("📝 Adding message to thread. Role: %s, Assistant: %s", role, assistantID)
-
-	requestBody := map[string]interface{}{
-		"role":    role,
-		"content": prompt,
-	}
+func (tm *ThreadManager) AddMessageToThread(ctx context.Context, role, prompt, assistantID, imageFileID string) error {
+	url := fmt.Sprintf("%s/threads/%s/messages", openAIBaseURL, tm.ThreadID)
 
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request body: %v", err)
-	}
+	slog.DebugContext(ctx, "Adding message to thread", "thread_id", tm.ThreadID, "role", role, "assistant_id", assistantID, logging.Payload("content", prompt))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %v", err)
+	var messageContent interface{} = prompt
+	if imageFileID != "" {
+		messageContent = []map[string]interface{}{
+			{"type": "text", "text": prompt},
+			{"type": "image_file", "image_file": map[string]string{"file_id": imageFileID}},
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+	requestBody := map[string]interface{}{
+		"role":    role,
+		"content": messageContent,
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("⚠️ Failed to add message to thread. StatusCode: %d, Response: %s", resp.StatusCode, string(bodyBytes))
-		return fmt.Errorf("failed to add message to thread: %s", string(bodyBytes))
+	if err := callOpenAI(ctx, "POST", url, requestBody, nil); err != nil {
+		slog.ErrorContext(ctx, "Failed to add message to thread", "thread_id", tm.ThreadID, "error", err)
+		return fmt.Errorf("failed to add message to thread: %w", err)
 	}
 
 	// ✅ Store both user and AI interactions under `assistant_id`
-	interactionKey := fmt.Sprintf("interactions:%s", assistantID)
+	interactionKey := interactionsKey(ctx, assistantID)
 	prefix := "User: "
 	if role == "assistant" {
 		prefix = "Assistant: "
 	}
-	
-	err = RedisClient.RPush(Ctx, interactionKey, prefix+prompt).Err()
+
+	err := RedisClient.RPush(ctx, interactionKey, prefix+prompt).Err()
 	if err == nil {
-		err = RedisClient.Expire(Ctx, interactionKey, 168*time.Hour).Err()
+		err = RedisClient.Expire(ctx, interactionKey, 168*time.Hour).Err()
 	}
-	
+
 	if err != nil {
-		log.Printf("⚠️ Failed to store interaction in Redis for Assistant: %s, Error: %v", assistantID, err)
+		slog.ErrorContext(ctx, "Failed to store interaction", "assistant_id", assistantID, "error", err)
 		return fmt.Errorf("failed to store interaction in Redis: %v", err)
 	}
 
-	log.Printf("✅ Interaction message stored in Redis for Assistant: %s", assistantID)
 	return nil
 }
 
-func (tm *ThreadManager) RunAssistant(assistantID string) (string, error) {
-	url := fmt.Sprintf("https://api.openai.com/v1/threads/%s/runs", tm.ThreadID)
-
-	requestBody := map[string]interface{}{
-		"assistant_id": assistantID,
-	}
-
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return "", fmt.Errorf("failed to create HTTP request: %v", err)
+func (tm *ThreadManager) RunAssistant(ctx context.Context, assistantID, additionalInstructions string) (string, error) {
+	if err := CheckBudget(ctx); err != nil {
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
+	url := fmt.Sprintf("%s/threads/%s/runs", openAIBaseURL, tm.ThreadID)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+	requestBody := map[string]interface{}{
+		"assistant_id": assistantID,
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to run assistant: %s", string(bodyBytes))
+	if additionalInstructions != "" {
+		requestBody["additional_instructions"] = additionalInstructions
 	}
 
 	var runResp struct {
 		ID     string `json:"id"`
 		Status string `json:"status"`
 	}
-	err = json.NewDecoder(resp.Body).Decode(&runResp)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	if err := callOpenAI(ctx, "POST", url, requestBody, &runResp); err != nil {
+		return "", fmt.Errorf("failed to run assistant: %w", err)
 	}
 
-	// Poll for completion
+	// Poll for completion, cancelling the run if the caller goes away
+	polls, status := 0, runResp.Status
+	defer func() { metrics.ObserveRunPolls(CallerFromContext(ctx).Endpoint, status, polls) }()
 	for {
-		time.Sleep(2 * time.Second)
-		status, err := tm.GetRunStatus(runResp.ID)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			tm.cancelRun(ctx, runResp.ID)
+			status = "abandoned"
+			return "", ctx.Err()
+		}
+
+		polls++
+		var err error
+		status, err = tm.GetRunStatus(ctx, runResp.ID)
 		if err != nil {
+			if ctx.Err() != nil {
+				tm.cancelRun(ctx, runResp.ID)
+				status = "abandoned"
+				return "", ctx.Err()
+			}
+			status = "error"
 			return "", fmt.Errorf("failed to get run status: %v", err)
 		}
 
+		switch status {
+		case "failed", "cancelled", "expired", "incomplete":
+			return "", fmt.Errorf("assistant run %s ended with status %s", runResp.ID, status)
+		}
+
 		if status == "completed" {
-			messages, err := tm.GetThreadMessages()
+			messages, err := tm.GetThreadMessages(ctx)
 			if err != nil {
 				return "", fmt.Errorf("failed to get thread messages: %v", err)
 			}
@@ -311,13 +319,13 @@ func (tm *ThreadManager) RunAssistant(assistantID string) (string, error) {
 					}
 
 					// ✅ Store assistant's response in Redis under assistant-specific key
-					err = RedisClient.RPush(Ctx, fmt.Sprintf("interactions:%s", assistantID), "Assistant: "+assistantResponse).Err()
+					err = RedisClient.RPush(ctx, interactionsKey(ctx, assistantID), "Assistant: "+assistantResponse).Err()
 					if err != nil {
-						log.Printf("Failed to store assistant response in Redis for Assistant %s: %v", assistantID, err)
+						slog.ErrorContext(ctx, "Failed to store assistant response", "assistant_id", assistantID, "error", err)
 						return "", fmt.Errorf("failed to store assistant response in Redis: %v", err)
 					}
 
-					log.Printf("✅ Assistant response stored in Redis for Assistant: %s", assistantID)
+					slog.DebugContext(ctx, "Assistant answered", "assistant_id", assistantID, "run_id", runResp.ID, logging.Payload("answer", assistantResponse))
 					return assistantResponse, nil
 				}
 			}
@@ -326,96 +334,63 @@ func (tm *ThreadManager) RunAssistant(assistantID string) (string, error) {
 	}
 }
 
-func (tm *ThreadManager) GetRunStatus(runID string) (string, error) {
-	url := fmt.Sprintf("https://api.openai.com/v1/threads/%s/runs/%s", tm.ThreadID, runID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create HTTP request: %v", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
+// cancelRun cancels an abandoned run so it stops consuming tokens and unlocks the thread for the next
+// question. It runs without the caller's cancellation because the caller is already done.
+func (tm *ThreadManager) cancelRun(ctx context.Context, runID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), openAIRequestTimeout)
+	defer cancel()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+	url := fmt.Sprintf("%s/threads/%s/runs/%s/cancel", openAIBaseURL, tm.ThreadID, runID)
+	if err := callOpenAI(ctx, "POST", url, map[string]interface{}{}, nil); err != nil {
+		slog.WarnContext(ctx, "Failed to cancel run", "run_id", runID, "thread_id", tm.ThreadID, "error", err)
+		return
 	}
-	defer resp.Body.Close()
+	slog.InfoContext(ctx, "Cancelled abandoned run", "run_id", runID, "thread_id", tm.ThreadID)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to get run status: %s", string(bodyBytes))
-	}
+func (tm *ThreadManager) GetRunStatus(ctx context.Context, runID string) (string, error) {
+	url := fmt.Sprintf("%s/threads/%s/runs/%s", openAIBaseURL, tm.ThreadID, runID)
 
 	var runStatus struct {
 		Status string `json:"status"`
 	}
-	err = json.NewDecoder(resp.Body).Decode(&runStatus)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode response: %v", err)
+	if err := callOpenAI(ctx, "GET", url, nil, &runStatus); err != nil {
+		return "", fmt.Errorf("failed to get run status: %w", err)
 	}
 
 	return runStatus.Status, nil
 }
 
-func (tm *ThreadManager) GetThreadMessages() ([]Message, error) {
-	url := fmt.Sprintf("https://api.openai.com/v1/threads/%s/messages", tm.ThreadID)
+func (tm *ThreadManager) GetThreadMessages(ctx context.Context) ([]Message, error) {
+	url := fmt.Sprintf("%s/threads/%s/messages", openAIBaseURL, tm.ThreadID)
 
 	// Log the retrieval request
-	log.Printf("Fetching messages from thread with ID: %s", tm.ThreadID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Printf("Failed to create HTTP request for thread message retrieval: %v", err)
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("OPENAI_API_KEY")))
-	req.Header.Set("OpenAI-Beta", "assistants=v2")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Failed to send request to get thread messages: %v", err)
-		return nil, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		log.Printf("Failed to fetch thread messages. StatusCode: %d, Response: %s", resp.StatusCode, string(bodyBytes))
-		return nil, fmt.Errorf("failed to get thread messages: %s", string(bodyBytes))
-	}
-
-	// Log the raw response body from OpenAI for debugging purposes
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed to read response body: %v", err)
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	log.Printf("Raw thread messages response: %s", string(bodyBytes))
+	slog.DebugContext(ctx, "Fetching thread messages", "thread_id", tm.ThreadID)
 
 	var messagesResp struct {
 		Data []Message `json:"data"`
 	}
-	err = json.Unmarshal(bodyBytes, &messagesResp)
-	if err != nil {
-		log.Printf("Failed to decode thread messages response: %v", err)
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+	if err := callOpenAI(ctx, "GET", url, nil, &messagesResp); err != nil {
+		slog.ErrorContext(ctx, "Failed to fetch thread messages", "thread_id", tm.ThreadID, "error", err)
+		return nil, fmt.Errorf("failed to get thread messages: %w", err)
 	}
 
 	// Log successful message retrieval
-	log.Printf("Successfully fetched %d messages from thread with ID: %s", len(messagesResp.Data), tm.ThreadID)
+	slog.DebugContext(ctx, "Fetched thread messages", "thread_id", tm.ThreadID, "messages", len(messagesResp.Data))
 	return messagesResp.Data, nil
 }
 
-func createPrompt(question string, timestamp int) string {
-	// Format the timestamp as mm:ss
-	// Create the prompt by appending the timestamp to the question
-	return fmt.Sprintf("At the timestamp <%d>, user asks: %s, Give a response based on the context of the video around the timestamp. Don't include the timestamp in your response. Sound natural, and human", timestamp, question)
+func createPrompt(promptVersion, question string, timestamp int, hasFrame bool, language string) (string, error) {
+	languageName := ""
+	if language != "" {
+		languageName = LanguageName(language)
+	}
+	return prompts.Render(promptVersion, prompts.Question, map[string]interface{}{
+		"Timestamp": timestamp,
+		"Question":  question,
+		"HasFrame":  hasFrame,
+		"Language":  languageName,
+	})
 }
 
 type TextContent struct {
@@ -435,40 +410,56 @@ type Message struct {
 	Content []ContentFragment `json:"content"` // Content is now a list of fragments
 }
 
-// GenerateSummary takes a video ID, retrieves the transcript from Redis, and returns a concise summary.
-func GenerateSummary(transcript string) (string, error) {
+// GenerateSummary takes a transcript and returns a concise summary written in the given language.
+func GenerateSummary(ctx context.Context, transcript, language, promptVersion string) (string, error) {
 	if transcript == "" {
 		return "", fmt.Errorf("transcript is empty")
 	}
 
-	systemPrompt := "You are a professional assistant specializing in summarizing video content. Your summaries should be structured, concise, and focused on the key ideas, themes, and takeaways from the video. Exclude unnecessary details or repetitive information. Present the summary in a clear and organized format with headings if applicable."
-	prompt := fmt.Sprintf("Please summarize the following video transcript. Focus on the key topics, main arguments, and actionable takeaways. Exclude irrelevant details, filler, or repetitive information, title of the video. Organize the summary into the following sections:\n\n1. Overview: Briefly introduce the video and its main purpose.\n2. Key Points: Outline the major ideas, concepts, or arguments presented.\n3. Conclusion: Summarize the overall message or conclusions drawn in the video.\n\nTranscript:\n%s", transcript)
+	systemPrompt, err := prompts.Render(promptVersion, prompts.SummarySystem, nil)
+	if err != nil {
+		return "", err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.Summary, map[string]interface{}{
+		"Transcript": transcript,
+		"Language":   LanguageName(language),
+	})
+	if err != nil {
+		return "", err
+	}
 
 	temperature := 0.8
 	maxTokens := 16000
-	response, err := CallGPT(prompt, systemPrompt, temperature, maxTokens)
+	response, err := CallGPT(ctx, prompt, systemPrompt, temperature, maxTokens)
 	if err != nil {
 		return "", fmt.Errorf("GPT call failed: %v", err)
 	}
 	return response, nil
 }
 
-func GenerateQuiz(transcript string) (map[string]interface{}, error) {
+func GenerateQuiz(ctx context.Context, transcript, language, promptVersion string) (map[string]interface{}, error) {
 	if transcript == "" {
 		return nil, fmt.Errorf("transcript is empty")
 	}
-	systemPrompt := "You are a helpful assistant that generates multiple choice questions given the full video transcript."
-	prompt := fmt.Sprintf("Generate 10 multiple-choice questions in structured JSON format based on the following transcript. Each question must have exactly one correct answer. If multiple valid answers are mentioned in the transcript, only include one of them as part of the options. The questions should be based on the transcript and should not be outside the transcript. Ensure that the answer field exactly matches one of the provided options. Do NOT add any letters like 'A, B, C, D' before the options. Just provide the options. Transcript:\n\n%s", transcript)
-	response, err := CallGPT2(prompt, systemPrompt)
+	systemPrompt, err := prompts.Render(promptVersion, prompts.QuizSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.Quiz, map[string]interface{}{
+		"Transcript": transcript,
+		"Language":   LanguageName(language),
+	})
+	if err != nil {
+		return nil, err
+	}
+	response, err := CallGPT2(ctx, prompt, systemPrompt)
 	if err != nil {
 		return nil, fmt.Errorf("GPT call failed: %v", err)
 	}
 	return response, nil
 }
 
-func CallGPT(prompt string, systemPrompt string, temperature float64, maxTokens int) (string, error) {
-	apiURL := "https://api.openai.com/v1/chat/completions"
-
+func CallGPT(ctx context.Context, prompt string, systemPrompt string, temperature float64, maxTokens int) (string, error) {
 	requestBody := map[string]interface{}{
 		"model": "gpt-4o-mini", // or gpt-3.5-turbo for lower cost
 		"messages": []map[string]string{
@@ -480,43 +471,11 @@ func CallGPT(prompt string, systemPrompt string, temperature float64, maxTokens
 		"max_tokens":  maxTokens,
 	}
 
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("GPT API call failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("GPT API error: %s", string(body))
-	}
-
-	var gptResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&gptResponse); err != nil {
-		return "", fmt.Errorf("failed to decode GPT response: %v", err)
-	}
-
 	// Extract the summary from the assistant's message
-	return gptResponse["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})["content"].(string), nil
+	return chatCompletionContent(ctx, requestBody)
 }
 
-func CallGPT2(prompt string, systemPrompt string) (map[string]interface{}, error) {
-	apiURL := "https://api.openai.com/v1/chat/completions"
-
+func CallGPT2(ctx context.Context, prompt string, systemPrompt string) (map[string]interface{}, error) {
 	// Define the request body
 	requestBody := map[string]interface{}{
 		"model": "gpt-4o-mini", // Replace with another model if required
@@ -561,8 +520,15 @@ func CallGPT2(prompt string, systemPrompt string) (map[string]interface{}, error
 									"answer": map[string]interface{}{
 										"type": "string",
 									},
+									"topic": map[string]interface{}{
+										"type": "string", // Short topic label used to track mastery
+									},
+									"difficulty": map[string]interface{}{
+										"type": "string",
+										"enum": []string{"easy", "medium", "hard"},
+									},
 								},
-								"required":             []string{"text", "timestamp", "options", "answer"},
+								"required":             []string{"text", "timestamp", "options", "answer", "topic", "difficulty"},
 								"additionalProperties": false,
 							},
 						},
@@ -577,41 +543,34 @@ func CallGPT2(prompt string, systemPrompt string) (map[string]interface{}, error
 		"max_tokens":  10000, // Adjust based on expected response size
 	}
 
-	// Marshal the request body into JSON
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
-
-	// Send the request
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("GPT API call failed: %v", err)
+	// Send the request and parse the response
+	var gptResponse map[string]interface{}
+	if err := chatCompletion(ctx, requestBody, &gptResponse); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Handle non-200 responses
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("GPT API error: %s", string(body))
-	}
+	return gptResponse, nil
+}
 
-	// Parse the response
-	var gptResponse map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&gptResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode GPT response: %v", err)
+// CallGPTJSON runs a chat completion constrained to the given JSON schema and returns the raw JSON content.
+func CallGPTJSON(ctx context.Context, prompt, systemPrompt, schemaName string, schema map[string]interface{}, temperature float64, maxTokens int) (string, error) {
+	requestBody := map[string]interface{}{
+		"model": "gpt-4o-mini",
+		"messages": []map[string]string{
+			{"role": "system", "content": systemPrompt},
+			{"role": "user", "content": prompt},
+		},
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   schemaName,
+				"schema": schema,
+				"strict": true,
+			},
+		},
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
 	}
 
-	return gptResponse, nil
+	return chatCompletionContent(ctx, requestBody)
 }
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultLanguage = "en"
+
+// languageNames maps ISO 639-1 codes to the names used in prompts
+var languageNames = map[string]string{
+	"ar": "Arabic",
+	"bn": "Bengali",
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fa": "Persian",
+	"fr": "French",
+	"hi": "Hindi",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"ur": "Urdu",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// NormalizeLanguage lowercases a language tag and strips any region, e.g. "pt-BR" -> "pt"
+func NormalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if idx := strings.IndexAny(lang, "-_"); idx != -1 {
+		lang = lang[:idx]
+	}
+	return lang
+}
+
+// LanguageName returns a human readable language name for prompts
+func LanguageName(lang string) string {
+	if name, ok := languageNames[lang]; ok {
+		return name
+	}
+	return lang
+}
+
+// DetectLanguage asks the model for the ISO 639-1 code of the transcript's language
+func DetectLanguage(ctx context.Context, transcript string) (string, error) {
+	sample := transcript
+	if len(sample) > 2000 {
+		sample = strings.ToValidUTF8(sample[:2000], "") // Do not split a multi-byte character
+	}
+
+	promptVersion := prompts.ResolveVersion("")
+	systemPrompt, err := prompts.Render(promptVersion, prompts.LanguageDetectionSystem, nil)
+	if err != nil {
+		return "", err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.LanguageDetection, map[string]interface{}{"Text": sample})
+	if err != nil {
+		return "", err
+	}
+	response, err := CallGPT(ctx, prompt, systemPrompt, 0, 5)
+	if err != nil {
+		return "", fmt.Errorf("GPT call failed: %v", err)
+	}
+
+	lang := NormalizeLanguage(strings.Trim(response, " \n\t.\"'"))
+	if len(lang) != 2 {
+		return "", fmt.Errorf("unexpected language code: %q", response)
+	}
+	return lang, nil
+}
+
+// GetTranscriptLanguage returns the detected language of a video's transcript, detecting and caching it on first use
+func GetTranscriptLanguage(ctx context.Context, videoID, transcript string) (string, error) {
+	key := "transcript_language:" + videoID
+	lang, err := RedisClient.Get(ctx, key).Result()
+	if err == nil {
+		return lang, nil
+	} else if err != redis.Nil {
+		return "", fmt.Errorf("error retrieving transcript language from Redis: %v", err)
+	}
+
+	lang, err = DetectLanguage(ctx, transcript)
+	if err != nil {
+		return "", err
+	}
+
+	if err := RedisClient.Set(ctx, key, lang, 168*time.Hour).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to cache transcript language", "video_id", videoID, "error", err)
+	}
+	slog.InfoContext(ctx, "Detected transcript language", "video_id", videoID, "language", lang)
+	return lang, nil
+}
+
+// ResolveLanguage picks the output language: the requested one if set, otherwise the transcript's own language
+func ResolveLanguage(ctx context.Context, requested, videoID, transcript string) string {
+	if lang := NormalizeLanguage(requested); lang != "" {
+		return lang
+	}
+
+	lang, err := GetTranscriptLanguage(ctx, videoID, transcript)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to detect language, using the default", "video_id", videoID, "language", defaultLanguage, "error", err)
+		return defaultLanguage
+	}
+	return lang
+}
+
+// PrepareTranscript loads a video's source transcript, resolves the output language, translates the
+// transcript when needed and appends any visual transcript. An empty transcript means none is stored.
+func PrepareTranscript(ctx context.Context, videoID, requestedLang string) (string, string, error) {
+	transcript, err := GetSourceTranscript(ctx, videoID)
+	if err != nil || transcript == "" {
+		return "", "", err
+	}
+
+	lang := ResolveLanguage(ctx, requestedLang, videoID, transcript)
+	// Without a requested language the output is in the transcript's own language
+	if NormalizeLanguage(requestedLang) != "" {
+		transcript, err = GetTranslatedTranscript(ctx, videoID, transcript, lang)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	visual, err := GetVisualTranscriptFromRedis(ctx, videoID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load visual transcript", "video_id", videoID, "error", err)
+	}
+	return CombineTranscripts(transcript, visual), lang, nil
+}
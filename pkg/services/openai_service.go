@@ -0,0 +1,449 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	openAIBaseURL = "https://api.openai.com/v1"
+
+	// Per-attempt timeouts. Completions over whole transcripts take far longer than assistant,
+	// thread and run bookkeeping calls.
+	openAIRequestTimeout    = 30 * time.Second
+	openAICompletionTimeout = 120 * time.Second
+	openAIUploadTimeout     = 60 * time.Second
+
+	// Bounds of the exponential backoff between attempts
+	openAIBaseBackoff = 500 * time.Millisecond
+	openAIMaxBackoff  = 20 * time.Second
+)
+
+// openAIClient is shared by every OpenAI call so connections are pooled. Timeouts are set per call.
+var openAIClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// OpenAIError is a non-2xx response from the OpenAI API
+type OpenAIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+	retryAfter time.Duration
+}
+
+func (e *OpenAIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("OpenAI API error %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("OpenAI API error %d: %s", e.StatusCode, e.Message)
+}
+
+// RetryAfter returns the delay the API asked for before retrying, or 0
+func (e *OpenAIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Retryable reports whether the request may succeed if sent again: rate limits, timeouts and server
+// errors are retryable, while bad requests, auth failures, conflicts (such as a thread's active run)
+// and exhausted quota are fatal.
+func (e *OpenAIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests:
+		return e.Code != "insufficient_quota"
+	case http.StatusRequestTimeout:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// newOpenAIError builds an OpenAIError from a failed response, reading the API's error object if present
+func newOpenAIError(resp *http.Response, body []byte) *OpenAIError {
+	apiErr := &OpenAIError{StatusCode: resp.StatusCode, Message: string(body), retryAfter: retryAfter(resp.Header)}
+
+	var errResp struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
+		apiErr.Message = errResp.Error.Message
+		apiErr.Type = errResp.Error.Type
+		apiErr.Code = errResp.Error.Code
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// retryAfter returns the delay the API asked for, from retry-after-ms or Retry-After (seconds or HTTP date)
+func retryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// isRetryable classifies an error from a single attempt of r. Requests that are not safe to repeat
+// are only retried when rate limited, as the API rejects those without processing them; after a
+// timeout or server error the first attempt may have landed, and a retry would duplicate it.
+func isRetryable(r openAIRequest, err error) bool {
+	var apiErr *OpenAIError
+	if errors.As(err, &apiErr) {
+		if !r.retrySafe() {
+			return apiErr.StatusCode == http.StatusTooManyRequests && apiErr.Retryable()
+		}
+		return apiErr.Retryable()
+	}
+	if !r.retrySafe() {
+		return false
+	}
+	// Network failures and per-attempt timeouts
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the delay before the given retry: exponential with full jitter, but never shorter
+// than the delay the API asked for
+func backoff(retry int, err error) time.Duration {
+	delay := openAIBaseBackoff << retry
+	if delay > openAIMaxBackoff || delay <= 0 {
+		delay = openAIMaxBackoff
+	}
+	delay = time.Duration(rand.Int63n(int64(delay)) + 1)
+
+	var apiErr *OpenAIError
+	if errors.As(err, &apiErr) && apiErr.retryAfter > delay {
+		delay = apiErr.retryAfter
+	}
+	return delay
+}
+
+// openAIRequest describes one OpenAI API call. Body is kept as bytes so it can be resent on retry.
+type openAIRequest struct {
+	Method      string
+	URL         string
+	Body        []byte
+	ContentType string
+	Assistants  bool // Send the Assistants v2 beta header
+	Timeout     time.Duration
+}
+
+// idempotentOpenAIAPIs are the POST APIs that create nothing, so sending them twice is harmless
+var idempotentOpenAIAPIs = map[string]bool{
+	"chat/completions":              true,
+	"embeddings":                    true,
+	"moderations":                   true,
+	"threads/{id}/runs/{id}/cancel": true,
+}
+
+// retrySafe reports whether r can be sent again even if an earlier attempt may have been processed
+func (r openAIRequest) retrySafe() bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodDelete || idempotentOpenAIAPIs[openAIAPI(r.URL)]
+}
+
+// openAIIDPrefixes mark the path segments of OpenAI URLs that are object IDs
+var openAIIDPrefixes = []string{"thread_", "run_", "asst_", "msg_", "step_", "file-", "vs_", "vsfb_"}
+
+// openAIAPI returns the API a URL calls with object IDs replaced, e.g. threads/{id}/runs/{id},
+// for metric labels
+func openAIAPI(url string) string {
+	path := strings.TrimPrefix(url, openAIBaseURL+"/")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		for _, prefix := range openAIIDPrefixes {
+			if strings.HasPrefix(segment, prefix) {
+				segments[i] = "{id}"
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// requestModel returns the model named in a JSON request body, if any
+func requestModel(r openAIRequest) string {
+	if r.ContentType != "application/json" || len(r.Body) == 0 {
+		return ""
+	}
+	var body struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(r.Body, &body)
+	return body.Model
+}
+
+// errorClass classifies a failed OpenAI call for metrics
+func errorClass(err error) string {
+	var apiErr *OpenAIError
+	switch {
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		case apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusGatewayTimeout:
+			return "timeout"
+		case apiErr.StatusCode >= 500:
+			return "server_error"
+		}
+		return "client_error"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "network"
+}
+
+// doOpenAI sends a request, retrying retryable failures with backoff (see isRetryable), and returns the response body.
+// Cancelling ctx aborts the attempt in flight and any remaining retries. Token usage reported in
+// the response is recorded against the caller in ctx, and the call's latency and outcome in metrics.
+func doOpenAI(ctx context.Context, r openAIRequest) (body []byte, err error) {
+	endpoint, model, api := CallerFromContext(ctx).Endpoint, requestModel(r), openAIAPI(r.URL)
+	start := time.Now()
+	defer func() {
+		class := ""
+		if err != nil {
+			class = errorClass(err)
+		}
+		metrics.ObserveModelCall(endpoint, model, api, time.Since(start), class)
+	}()
+
+	var lastErr error
+	for attempt := 0; attempt <= config.OpenAIMaxRetries; attempt++ {
+		if attempt > 0 {
+			metrics.ModelRetry(endpoint, model, api)
+			delay := backoff(attempt-1, lastErr)
+			slog.WarnContext(ctx, "Retrying OpenAI request", "method", r.Method, "url", r.URL, "delay_ms", delay.Milliseconds(), "attempt", attempt+1, "max_attempts", config.OpenAIMaxRetries+1, "error", lastErr)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		body, err = sendOpenAI(ctx, r)
+		if err == nil {
+			recordResponseUsage(ctx, body)
+			return body, nil
+		}
+		lastErr = err
+		// A cancelled or expired caller is not a failed attempt
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !isRetryable(r, err) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// sendOpenAI makes a single attempt, bounded by the request timeout and by ctx
+func sendOpenAI(ctx context.Context, r openAIRequest) ([]byte, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = openAIRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if r.Body != nil {
+		reqBody = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	if r.ContentType != "" {
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+	if r.Assistants {
+		req.Header.Set("OpenAI-Beta", "assistants=v2")
+	}
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newOpenAIError(resp, body)
+	}
+	return body, nil
+}
+
+// callOpenAI sends a JSON request to an Assistants v2 endpoint and decodes the JSON response into out
+func callOpenAI(ctx context.Context, method, url string, payload interface{}, out interface{}) error {
+	return callOpenAIWithTimeout(ctx, method, url, payload, out, openAIRequestTimeout)
+}
+
+// callOpenAIWithTimeout is callOpenAI with a per-attempt timeout
+func callOpenAIWithTimeout(ctx context.Context, method, url string, payload interface{}, out interface{}, timeout time.Duration) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to marshal request body: %v", err)
+		}
+	}
+
+	respBody, err := doOpenAI(ctx, openAIRequest{
+		Method:      method,
+		URL:         url,
+		Body:        body,
+		ContentType: "application/json",
+		Assistants:  true,
+		Timeout:     timeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// UploadFile uploads content to the OpenAI files API and returns the file ID
+func UploadFile(ctx context.Context, purpose, filename string, content []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("purpose", purpose); err != nil {
+		return "", fmt.Errorf("failed to write purpose field: %v", err)
+	}
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		return "", fmt.Errorf("failed to write file content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+
+	respBody, err := doOpenAI(ctx, openAIRequest{
+		Method:      "POST",
+		URL:         openAIBaseURL + "/files",
+		Body:        body.Bytes(),
+		ContentType: writer.FormDataContentType(),
+		Timeout:     openAIUploadTimeout,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to upload file", "filename", filename, "error", err)
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	var fileResp struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &fileResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %v", err)
+	}
+	return fileResp.ID, nil
+}
+
+// DeleteFile deletes a file from the OpenAI files API
+func DeleteFile(ctx context.Context, fileID string) error {
+	if err := callOpenAI(ctx, "DELETE", openAIBaseURL+"/files/"+fileID, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// chatCompletion runs a chat completion request and decodes the response into out
+func chatCompletion(ctx context.Context, requestBody map[string]interface{}, out interface{}) error {
+	if err := CheckBudget(ctx); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	respBody, err := doOpenAI(ctx, openAIRequest{
+		Method:      "POST",
+		URL:         openAIBaseURL + "/chat/completions",
+		Body:        body,
+		ContentType: "application/json",
+		Timeout:     openAICompletionTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("GPT API call failed: %w", err)
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode GPT response: %v", err)
+	}
+	return nil
+}
+
+// chatCompletionContent runs a chat completion request and returns the content of the first choice
+func chatCompletionContent(ctx context.Context, requestBody map[string]interface{}) (string, error) {
+	var gptResponse struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := chatCompletion(ctx, requestBody, &gptResponse); err != nil {
+		return "", err
+	}
+	if len(gptResponse.Choices) == 0 {
+		return "", fmt.Errorf("GPT response has no choices")
+	}
+	return gptResponse.Choices[0].Message.Content, nil
+}
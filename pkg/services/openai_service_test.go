@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	chat := openAIRequest{Method: "POST", URL: openAIBaseURL + "/chat/completions"}
+	embeddings := openAIRequest{Method: "POST", URL: openAIBaseURL + "/embeddings"}
+	getRun := openAIRequest{Method: "GET", URL: openAIBaseURL + "/threads/thread_abc/runs/run_123"}
+	createRun := openAIRequest{Method: "POST", URL: openAIBaseURL + "/threads/thread_abc/runs"}
+	upload := openAIRequest{Method: "POST", URL: openAIBaseURL + "/files"}
+
+	apiError := func(status int, code string) error {
+		return fmt.Errorf("request failed: %w", &OpenAIError{StatusCode: status, Code: code})
+	}
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name    string
+		request openAIRequest
+		err     error
+		want    bool
+	}{
+		{name: "chat rate limited", request: chat, err: apiError(429, "rate_limit_exceeded"), want: true},
+		{name: "chat quota exhausted", request: chat, err: apiError(429, "insufficient_quota"), want: false},
+		{name: "chat server error", request: chat, err: apiError(503, ""), want: true},
+		{name: "chat request timeout", request: chat, err: apiError(408, ""), want: true},
+		{name: "chat bad request", request: chat, err: apiError(400, ""), want: false},
+		{name: "chat unauthorized", request: chat, err: apiError(401, ""), want: false},
+		{name: "chat network error", request: chat, err: netErr, want: true},
+		{name: "chat attempt timeout", request: chat, err: context.DeadlineExceeded, want: true},
+		{name: "chat truncated response", request: chat, err: io.ErrUnexpectedEOF, want: true},
+		{name: "chat other error", request: chat, err: errors.New("boom"), want: false},
+		{name: "embeddings server error", request: embeddings, err: apiError(500, ""), want: true},
+		{name: "get run server error", request: getRun, err: apiError(502, ""), want: true},
+		{name: "get run network error", request: getRun, err: netErr, want: true},
+		{name: "conflict is never retried", request: getRun, err: apiError(409, ""), want: false},
+		{name: "create run rate limited", request: createRun, err: apiError(429, ""), want: true},
+		{name: "create run server error", request: createRun, err: apiError(500, ""), want: false},
+		{name: "create run timeout", request: createRun, err: context.DeadlineExceeded, want: false},
+		{name: "create run network error", request: createRun, err: netErr, want: false},
+		{name: "create run conflict", request: createRun, err: apiError(409, ""), want: false},
+		{name: "upload rate limited", request: upload, err: apiError(429, ""), want: true},
+		{name: "upload quota exhausted", request: upload, err: apiError(429, "insufficient_quota"), want: false},
+		{name: "upload server error", request: upload, err: apiError(500, ""), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.request, tt.err); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIAPI(t *testing.T) {
+	tests := map[string]string{
+		openAIBaseURL + "/chat/completions":                             "chat/completions",
+		openAIBaseURL + "/threads/thread_abc/runs/run_123":              "threads/{id}/runs/{id}",
+		openAIBaseURL + "/threads/thread_abc/messages?order=desc":       "threads/{id}/messages",
+		openAIBaseURL + "/threads/thread_abc/runs/run_123/cancel":       "threads/{id}/runs/{id}/cancel",
+		openAIBaseURL + "/files/file-xyz":                               "files/{id}",
+		openAIBaseURL + "/vector_stores/vs_1/file_batches/vsfb_2":       "vector_stores/{id}/file_batches/{id}",
+		openAIBaseURL + "/assistants/asst_1":                            "assistants/{id}",
+		openAIBaseURL + "/threads/thread_abc/runs/run_123/steps/step_1": "threads/{id}/runs/{id}/steps/{id}",
+	}
+	for url, want := range tests {
+		if got := openAIAPI(url); got != want {
+			t.Errorf("openAIAPI(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "milliseconds", header: http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"9"}}, want: 250 * time.Millisecond},
+		{name: "seconds", header: http.Header{"Retry-After": {"2"}}, want: 2 * time.Second},
+		{name: "fractional seconds", header: http.Header{"Retry-After": {"0.5"}}, want: 500 * time.Millisecond},
+		{name: "missing", header: http.Header{}, want: 0},
+		{name: "invalid", header: http.Header{"Retry-After": {"soon"}}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.header); got != tt.want {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	date := http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}}
+	if got := retryAfter(date); got <= 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter() with an HTTP date = %v, want about a minute", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for retry := 0; retry < 10; retry++ {
+		max := openAIBaseBackoff << retry
+		if max > openAIMaxBackoff {
+			max = openAIMaxBackoff
+		}
+		if got := backoff(retry, errors.New("timeout")); got <= 0 || got > max {
+			t.Errorf("backoff(%d) = %v, want within (0, %v]", retry, got, max)
+		}
+	}
+
+	// The delay the API asks for is a floor
+	err := &OpenAIError{StatusCode: 429, retryAfter: time.Minute}
+	if got := backoff(0, err); got != time.Minute {
+		t.Errorf("backoff() with Retry-After = %v, want %v", got, time.Minute)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"fmt"
+	"strings"
+)
+
+// Personas supported by the assistant; each has a persona_<name> prompt template
+var Personas = []string{"tutor", "socratic", "eli5", "exam_prep"}
+
+// NormalizePersona maps user input such as "Exam-Prep" to a known persona name.
+// It returns an error for unknown personas and "" for empty input.
+func NormalizePersona(persona string) (string, error) {
+	persona = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(persona)), "-", "_")
+	if persona == "" {
+		return "", nil
+	}
+	for _, p := range Personas {
+		if p == persona {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownPersona, persona)
+}
+
+// ResolvePersona picks the persona from the request, then the tenant's configured persona, then the service default
+func ResolvePersona(requested, tenantID string) (string, error) {
+	for _, candidate := range []string{requested, config.TenantPersonas[tenantID], config.DefaultPersona} {
+		persona, err := NormalizePersona(candidate)
+		if err != nil {
+			return "", err
+		}
+		if persona != "" {
+			return persona, nil
+		}
+	}
+	return "", nil
+}
+
+// BuildAssistantInstructions layers the service default instructions, the persona and the
+// request's system instructions, and always ends with the guardrails so they cannot be overridden.
+func BuildAssistantInstructions(promptVersion string, initReq InitializeRequest, transcript, persona string) (string, error) {
+	defaults, err := prompts.Render(promptVersion, prompts.AssistantInstructions, map[string]interface{}{
+		"Title":      initReq.Title,
+		"Channel":    initReq.Channel,
+		"Transcript": transcript,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sections := []string{defaults}
+	if persona != "" {
+		personaInstructions, err := prompts.Render(promptVersion, prompts.Persona(persona), nil)
+		if err != nil {
+			return "", err
+		}
+		sections = append(sections, personaInstructions)
+	}
+	if custom := strings.TrimSpace(initReq.SystemInstructions); custom != "" {
+		sections = append(sections, "Additional instructions from the requester:\n"+custom)
+	}
+
+	guardrails, err := prompts.Render(promptVersion, prompts.Guardrails, nil)
+	if err != nil {
+		return "", err
+	}
+	sections = append(sections, guardrails)
+
+	return strings.Join(sections, "\n\n"), nil
+}
+
+// BuildPersonaInstructions returns run-level instructions switching the assistant to a persona for one question
+func BuildPersonaInstructions(promptVersion, persona string) (string, error) {
+	if persona == "" {
+		return "", nil
+	}
+
+	personaInstructions, err := prompts.Render(promptVersion, prompts.Persona(persona), nil)
+	if err != nil {
+		return "", err
+	}
+	guardrails, err := prompts.Render(promptVersion, prompts.Guardrails, nil)
+	if err != nil {
+		return "", err
+	}
+	return "For this answer: " + personaInstructions + "\n\n" + guardrails, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RateLimit allows Limit requests per sliding Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitResult is the outcome of counting one request against a limit
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // Until the oldest request in the window expires, when not allowed
+}
+
+// Built-in limits per "plan:endpoint"; "*" is the plan's default for endpoints without their own limit.
+// RATE_LIMITS entries override these.
+var defaultRateLimits = map[string]string{
+	"free:*":                               "60/1m",
+	"free:ask-question":                    "20/1m",
+	"free:courses/{courseID}/ask-question": "20/1m",
+	"free:generate-quiz":                   "10/1h",
+	"free:generate-summary":                "10/1h",
+	"free:generate-glossary":               "10/1h",
+	"pro:*":                                "300/1m",
+}
+
+// slidingWindowScript counts a request in a sorted set of request times, atomically so concurrent
+// requests cannot both take the last slot. Returns {allowed, remaining, retry after in ms}.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[4])
+  redis.call('PEXPIRE', KEYS[1], window)
+  return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+func rateLimitKey(ctx context.Context, identity, endpoint string) string {
+	return tenantKey(ctx, "ratelimit", identity+":"+endpoint)
+}
+
+func planKey(ctx context.Context, userID string) string {
+	return tenantKey(ctx, "plan", userID)
+}
+
+// ParseRateLimit parses "20/1m" into a limit of 20 requests per minute
+func ParseRateLimit(s string) (RateLimit, error) {
+	count, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected <limit>/<window>", s)
+	}
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 0 {
+		return RateLimit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid window in rate limit %q", s)
+	}
+	return RateLimit{Limit: limit, Window: duration}, nil
+}
+
+// RateLimitFor returns the limit of a plan on an endpoint, or nil if the endpoint is unlimited for the plan
+func RateLimitFor(plan, endpoint string) *RateLimit {
+	for _, key := range []string{plan + ":" + endpoint, plan + ":*"} {
+		value, ok := config.RateLimits[key]
+		if !ok {
+			value, ok = defaultRateLimits[key]
+		}
+		if !ok {
+			continue
+		}
+
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			slog.Warn("Ignoring invalid rate limit", "key", key, "error", err)
+			continue
+		}
+		return &limit
+	}
+	return nil
+}
+
+// GetUserPlan returns the plan of a user in the caller's tenant, written to plan:<tenant>:<userId> by
+// the account service. Plans written before tenants, to plan:<userId>, still apply in the default tenant.
+func GetUserPlan(ctx context.Context, userID string) string {
+	if userID == "" {
+		return config.DefaultPlan
+	}
+	plan, err := RedisClient.Get(ctx, planKey(ctx, userID)).Result()
+	if err == redis.Nil && CallerFromContext(ctx).Tenant() == DefaultTenant {
+		plan, err = RedisClient.Get(ctx, "plan:"+userID).Result()
+	}
+	if err != nil {
+		if err != redis.Nil {
+			slog.WarnContext(ctx, "Failed to load plan", "error", err)
+		}
+		return config.DefaultPlan
+	}
+	return plan
+}
+
+// CheckRateLimit counts a request by identity on endpoint against the limit
+func CheckRateLimit(ctx context.Context, identity, endpoint string, limit RateLimit) (*RateLimitResult, error) {
+	if limit.Limit == 0 {
+		return &RateLimitResult{RetryAfter: limit.Window}, nil
+	}
+
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10)
+	if suffix, err := newID(); err == nil {
+		member += "-" + suffix
+	}
+
+	values, err := slidingWindowScript.Run(ctx, RedisClient, []string{rateLimitKey(ctx, identity, endpoint)},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Limit, member).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %v", err)
+	}
+
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit.Limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimit
+		wantErr bool
+	}{
+		{in: "20/1m", want: RateLimit{Limit: 20, Window: time.Minute}},
+		{in: " 10/1h ", want: RateLimit{Limit: 10, Window: time.Hour}},
+		{in: "5/30s", want: RateLimit{Limit: 5, Window: 30 * time.Second}},
+		{in: "0/1m", want: RateLimit{Limit: 0, Window: time.Minute}},
+		{in: "20", wantErr: true},
+		{in: "x/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "20/minute", wantErr: true},
+		{in: "20/0s", wantErr: true},
+		{in: "20/-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRateLimit(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRateLimit(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRateLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	limits := config.RateLimits
+	config.RateLimits = map[string]string{
+		"free:generate-quiz": "2/1m",
+		"pro:ask-question":   "invalid",
+		"team:*":             "100/1m",
+	}
+	defer func() { config.RateLimits = limits }()
+
+	tests := []struct {
+		plan, endpoint string
+		want           *RateLimit
+	}{
+		{plan: "free", endpoint: "generate-quiz", want: &RateLimit{Limit: 2, Window: time.Minute}},
+		{plan: "free", endpoint: "ask-question", want: &RateLimit{Limit: 20, Window: time.Minute}},
+		{plan: "free", endpoint: "related", want: &RateLimit{Limit: 60, Window: time.Minute}},
+		{plan: "pro", endpoint: "ask-question", want: &RateLimit{Limit: 300, Window: time.Minute}},
+		{plan: "team", endpoint: "ask-question", want: &RateLimit{Limit: 100, Window: time.Minute}},
+		{plan: "enterprise", endpoint: "ask-question", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.plan+":"+tt.endpoint, func(t *testing.T) {
+			got := RateLimitFor(tt.plan, tt.endpoint)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("RateLimitFor(%q, %q) = %+v, want %+v", tt.plan, tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckRateLimit runs the sliding window script against the Redis at REDIS_TEST_ADDR
+func TestCheckRateLimit(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	ctx := context.Background()
+	client := RedisClient
+	RedisClient = redis.NewClient(&redis.Options{Addr: addr})
+	defer func() {
+		RedisClient.Close()
+		RedisClient = client
+	}()
+	if err := RedisClient.Ping(ctx).Err(); err != nil {
+		t.Skipf("Redis is not reachable: %v", err)
+	}
+
+	identity := "test-" + time.Now().Format("150405.000000000")
+	defer RedisClient.Del(ctx, rateLimitKey(ctx, identity, "quiz"), rateLimitKey(ctx, identity, "summary"))
+
+	limit := RateLimit{Limit: 3, Window: 500 * time.Millisecond}
+	steps := []struct {
+		name          string
+		endpoint      string
+		wait          time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{name: "first request", endpoint: "quiz", wantAllowed: true, wantRemaining: 2},
+		{name: "second request", endpoint: "quiz", wantAllowed: true, wantRemaining: 1},
+		{name: "last slot", endpoint: "quiz", wantAllowed: true, wantRemaining: 0},
+		{name: "over the limit", endpoint: "quiz", wantAllowed: false},
+		{name: "other endpoints are counted separately", endpoint: "summary", wantAllowed: true, wantRemaining: 2},
+		{name: "window has slid past the earlier requests", endpoint: "quiz", wait: 600 * time.Millisecond, wantAllowed: true, wantRemaining: 2},
+	}
+
+	for _, step := range steps {
+		time.Sleep(step.wait)
+		result, err := CheckRateLimit(ctx, identity, step.endpoint, limit)
+		if err != nil {
+			t.Fatalf("%s: CheckRateLimit() error = %v", step.name, err)
+		}
+		if result.Allowed != step.wantAllowed || result.Remaining != step.wantRemaining {
+			t.Errorf("%s: CheckRateLimit() = %+v, want allowed %v with %d remaining", step.name, result, step.wantAllowed, step.wantRemaining)
+		}
+		if !result.Allowed && (result.RetryAfter <= 0 || result.RetryAfter > limit.Window) {
+			t.Errorf("%s: RetryAfter = %v, want within the window", step.name, result.RetryAfter)
+		}
+	}
+}
+
+func TestCheckRateLimitZeroLimit(t *testing.T) {
+	// A zero limit blocks without touching Redis
+	result, err := CheckRateLimit(context.Background(), "user", "quiz", RateLimit{Limit: 0, Window: time.Minute})
+	if err != nil {
+		t.Fatalf("CheckRateLimit() error = %v", err)
+	}
+	if result.Allowed || result.RetryAfter != time.Minute {
+		t.Errorf("CheckRateLimit() = %+v, want blocked for a minute", result)
+	}
+}
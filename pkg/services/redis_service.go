@@ -2,19 +2,18 @@ package services
 
 import (
 	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-var (
-	RedisClient *redis.Client
-	Ctx         = context.Background() // Exported context to be used across the package
-)
+var RedisClient *redis.Client
 
 // Initialize Redis connection
 func InitRedis() {
@@ -30,20 +29,30 @@ func InitRedis() {
 		Addr:      config.RedisHost, // Redis address
 		TLSConfig: tlsConfig,
 	})
+	RedisClient.AddHook(metrics.RedisHook{})
 
-	err := RedisClient.Ping(Ctx).Err()
+	err := RedisClient.Ping(context.Background()).Err()
 	if err != nil {
 		panic(err)
 	}
 }
 
-// GetTranscriptFromRedis retrieves the transcript for a given video ID from Redis
-func GetTranscriptFromRedis(videoID string) (string, error) {
+// GetTranscriptFromRedis retrieves the transcript for a given video ID from Redis. Transcripts
+// ingested by this service are preferred; the bare video ID key written by other services is the fallback.
+func GetTranscriptFromRedis(ctx context.Context, videoID string) (string, error) {
+	stored, err := GetStoredTranscript(ctx, videoID)
+	if err != nil {
+		return "", err
+	}
+	if stored != nil {
+		return RenderSegments(stored.Segments), nil
+	}
+
 	key := videoID
-	log.Printf("Querying Redis with key: %s", key)
-	val, err := RedisClient.Get(Ctx, key).Result()
+	slog.DebugContext(ctx, "Querying Redis for transcript", "key", key)
+	val, err := RedisClient.Get(ctx, key).Result()
 	if err == redis.Nil {
-		log.Printf("Transcript not found for key: %s", key)
+		slog.DebugContext(ctx, "Transcript not found", "key", key)
 		return "", nil
 	} else if err != nil {
 		return "", fmt.Errorf("error retrieving from Redis: %v", err)
@@ -51,28 +60,93 @@ func GetTranscriptFromRedis(videoID string) (string, error) {
 	return val, nil
 }
 
-func StoreSummaryInRedis(videoID string, summary string) error {
-	return RedisClient.Set(Ctx, "summary:"+videoID, summary, 168*time.Hour).Err() // 1 week TTL
+func summaryKey(ctx context.Context, language, videoID string) string {
+	return tenantKey(ctx, "summary", language+":"+videoID)
+}
+
+func assistantKey(ctx context.Context, userID, videoID string) string {
+	return tenantKey(ctx, "assistant", userID+":"+videoID)
+}
+
+func interactionsKey(ctx context.Context, assistantID string) string {
+	return tenantKey(ctx, "interactions", assistantID)
+}
+
+// CachedSummary is a summary together with the prompt version that produced it
+type CachedSummary struct {
+	Summary       string `json:"summary"`
+	PromptVersion string `json:"prompt_version"`
+}
+
+func StoreSummaryInRedis(ctx context.Context, videoID, language, summary, promptVersion string) error {
+	data, err := json.Marshal(CachedSummary{Summary: summary, PromptVersion: promptVersion})
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %v", err)
+	}
+	return storeDerived(ctx, videoID, summaryKey(ctx, language, videoID), data, 168*time.Hour) // 1 week TTL
 }
 
-func GetSummaryFromRedis(videoID string) (string, error) {
-	summary, err := RedisClient.Get(Ctx, "summary:"+videoID).Result()
+// GetSummaryFromRedis returns the cached summary, or nil if there is none
+func GetSummaryFromRedis(ctx context.Context, videoID, language string) (*CachedSummary, error) {
+	val, err := RedisClient.Get(ctx, summaryKey(ctx, language, videoID)).Result()
 	if err == redis.Nil {
-		return "", nil
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cached CachedSummary
+	if err := json.Unmarshal([]byte(val), &cached); err != nil {
+		return nil, fmt.Errorf("failed to decode cached summary: %v", err)
 	}
-	return summary, err
+	return &cached, nil
 }
 
-func GetAssistantIDFromRedis(userID, videoID string) (string, error) {
-	ctx := context.Background()
-	redisKey := fmt.Sprintf("assistant:%s:%s", userID, videoID)
+// StoreAssistantID records the assistant of a user's session on a video, within the caller's tenant
+func StoreAssistantID(ctx context.Context, userID, videoID, assistantID string) error {
+	return RedisClient.Set(ctx, assistantKey(ctx, userID, videoID), assistantID, 168*time.Hour).Err()
+}
 
-	assistantID, err := RedisClient.Get(ctx, redisKey).Result()
+// GetAssistantIDFromRedis returns the assistant of a user's session on a video. Sessions created
+// before keys were scoped by tenant belong to the default tenant and are moved to its key on first use.
+func GetAssistantIDFromRedis(ctx context.Context, userID, videoID string) (string, error) {
+	assistantID, err := RedisClient.Get(ctx, assistantKey(ctx, userID, videoID)).Result()
+	if err == redis.Nil && CallerFromContext(ctx).Tenant() == DefaultTenant {
+		assistantID, err = migrateLegacyAssistant(ctx, userID, videoID)
+	}
 	if err == redis.Nil {
-		return "", fmt.Errorf("❌ AssistantID not found in Redis for UserID: %s and VideoID: %s", userID, videoID)
+		return "", fmt.Errorf("%w for UserID: %s and VideoID: %s", ErrSessionNotFound, userID, videoID)
 	} else if err != nil {
 		return "", fmt.Errorf("⚠️ Redis error: %v", err)
 	}
 
 	return assistantID, nil
 }
+
+// migrateLegacyAssistant moves a session stored under assistant:<userId>:<videoID>, and its
+// interaction history, to the default tenant's keys. Returns redis.Nil if there is no such session.
+func migrateLegacyAssistant(ctx context.Context, userID, videoID string) (string, error) {
+	legacyKey := fmt.Sprintf("assistant:%s:%s", userID, videoID)
+	assistantID, err := RedisClient.Get(ctx, legacyKey).Result()
+	if err != nil {
+		return "", err
+	}
+
+	ttl := RedisClient.TTL(ctx, legacyKey).Val()
+	if ttl <= 0 {
+		ttl = 168 * time.Hour
+	}
+	if err := RedisClient.Set(ctx, assistantKey(ctx, userID, videoID), assistantID, ttl).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to migrate session", "assistant_id", assistantID, "error", err)
+		return assistantID, nil
+	}
+	legacyInteractions := "interactions:" + assistantID
+	if n, _ := RedisClient.Exists(ctx, legacyInteractions).Result(); n > 0 {
+		if err := RedisClient.Rename(ctx, legacyInteractions, interactionsKey(ctx, assistantID)).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to migrate interaction history", "assistant_id", assistantID, "error", err)
+		}
+	}
+	RedisClient.Del(ctx, legacyKey)
+	slog.InfoContext(ctx, "Migrated session to tenant keys", "assistant_id", assistantID, "video_id", videoID)
+	return assistantID, nil
+}
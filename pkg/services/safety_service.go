@@ -0,0 +1,141 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Reason codes of prompt-injection attempts. Moderation flags use "moderation_<category>".
+const (
+	ReasonIgnoreInstructions = "injection_ignore_instructions"
+	ReasonRoleOverride       = "injection_role_override"
+	ReasonPromptLeak         = "injection_prompt_leak"
+	ReasonJailbreak          = "injection_jailbreak"
+	ReasonSpecialTokens      = "injection_special_tokens"
+	ReasonDelimiterSpoof     = "injection_delimiter_spoof"
+)
+
+// injectionPatterns are common ways of smuggling instructions into a question or a transcript.
+// They are kept narrow so that questions about a video's content are not caught.
+var injectionPatterns = []struct {
+	reason  string
+	pattern *regexp.Regexp
+}{
+	{ReasonIgnoreInstructions, regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+|your\s+|of\s+)*(previous|prior|above|earlier|preceding|system|original)\s+(instructions|prompts?|rules|directions)\b`)},
+	{ReasonRoleOverride, regexp.MustCompile(`(?i)\b(new|updated)\s+(system\s+)?instructions\s*:|\byou\s+are\s+now\s+(in\s+)?(developer|god|admin|unrestricted|jailbreak|dan)\b|\bpretend\s+(that\s+)?you\s+(have\s+no|are\s+not\s+bound\s+by)\b`)},
+	{ReasonPromptLeak, regexp.MustCompile(`(?i)\b(reveal|show|print|repeat|output|list)\s+(me\s+)?(your|the)\s+(system\s+prompt|(initial|hidden|original|system)\s+(prompt|instructions))\b|\b(reveal|show|print|repeat|output)\s+(me\s+)?your\s+(prompt|instructions)\b`)},
+	{ReasonJailbreak, regexp.MustCompile(`(?i)\b(dan\s+mode|do\s+anything\s+now|developer\s+mode\s+(enabled|on))\b|\b(answer|respond|reply)\s+without\s+(any\s+)?(restrictions|filters|censorship|guidelines|rules)\b`)},
+	{ReasonSpecialTokens, regexp.MustCompile(`(?im)<\|(im_start|im_end|system|endoftext)\|>|\[/?INST\]|^\s*#{2,}\s*(system|instructions?)\s*:?\s*$`)},
+	{ReasonDelimiterSpoof, regexp.MustCompile(`(?i)<<<\s*(BEGIN|END)\s+UNTRUSTED`)},
+}
+
+// FlaggedError is returned for content that was blocked by the safety checks
+type FlaggedError struct {
+	Reason     string   // Stable reason code, e.g. injection_prompt_leak or moderation_harassment
+	Categories []string // Moderation categories that were flagged, if any
+}
+
+func (e *FlaggedError) Error() string {
+	return fmt.Sprintf("content flagged: %s", e.Reason)
+}
+
+func (e *FlaggedError) Unwrap() error {
+	return ErrContentFlagged
+}
+
+// DetectInjection returns the reason code of the first prompt-injection pattern found in text, or ""
+func DetectInjection(text string) string {
+	for _, p := range injectionPatterns {
+		if p.pattern.MatchString(text) {
+			return p.reason
+		}
+	}
+	return ""
+}
+
+// Moderate runs text through the OpenAI moderation endpoint and returns the flagged categories,
+// sorted, or none if the text is acceptable
+func Moderate(ctx context.Context, text string) ([]string, error) {
+	var moderationResp struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	err := callOpenAIWithTimeout(ctx, "POST", openAIBaseURL+"/moderations", map[string]interface{}{
+		"model": config.ModerationModel,
+		"input": text,
+	}, &moderationResp, openAIRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+
+	var flagged []string
+	for _, result := range moderationResp.Results {
+		if !result.Flagged {
+			continue
+		}
+		for category, hit := range result.Categories {
+			if hit {
+				flagged = append(flagged, category)
+			}
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
+
+// moderationReason turns a moderation category such as self-harm/intent into a reason code
+func moderationReason(category string) string {
+	return "moderation_" + strings.NewReplacer("/", "_", "-", "_").Replace(category)
+}
+
+// ScreenUserInput checks learner text, such as a question, before it reaches a model. Injection
+// attempts are blocked unless INJECTION_GUARD is log or off; content flagged by moderation is
+// always blocked. A failing moderation call is logged and lets the text through, so an outage of
+// the moderation endpoint does not take questions down with it.
+func ScreenUserInput(ctx context.Context, kind, text string) error {
+	if config.InjectionGuard != "off" {
+		if reason := DetectInjection(text); reason != "" {
+			if config.InjectionGuard == "block" {
+				slog.WarnContext(ctx, "Blocked user input", "kind", kind, "reason", reason)
+				return &FlaggedError{Reason: reason}
+			}
+			slog.WarnContext(ctx, "Flagged user input", "kind", kind, "reason", reason)
+		}
+	}
+
+	if !config.ModerationEnabled {
+		return nil
+	}
+	categories, err := Moderate(ctx, text)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.WarnContext(ctx, "Skipping moderation", "kind", kind, "error", err)
+		return nil
+	}
+	if len(categories) > 0 {
+		reason := moderationReason(categories[0])
+		slog.WarnContext(ctx, "Blocked user input", "kind", kind, "reason", reason, "categories", categories)
+		return &FlaggedError{Reason: reason, Categories: categories}
+	}
+	return nil
+}
+
+// ScanTranscript logs prompt-injection patterns found in a video's transcript. Transcripts are not
+// blocked, since only the video's creator controls them; they always reach models as untrusted content.
+func ScanTranscript(ctx context.Context, videoID, text string) {
+	if config.InjectionGuard == "off" {
+		return
+	}
+	if reason := DetectInjection(text); reason != "" {
+		slog.WarnContext(ctx, "Transcript contains a possible prompt injection", "video_id", videoID, "reason", reason)
+	}
+}
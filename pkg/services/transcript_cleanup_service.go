@@ -0,0 +1,393 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Number of caption lines sent to the model per cleanup request
+	cleanupChunkSize = 80
+	// Longest a background cleanup may run; also how long other cleanups of the video wait for it
+	cleanupTimeout = 10 * time.Minute
+)
+
+// CleanSentence is a punctuated sentence with the original caption lines it was made from
+type CleanSentence struct {
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	Text          string  `json:"text"`
+	SpeakerChange bool    `json:"speaker_change"`
+	FirstLine     int     `json:"first_line"` // Index of the first original segment
+	LastLine      int     `json:"last_line"`  // Index of the last original segment, inclusive
+}
+
+// CleanedTranscript is a transcript rewritten into sentences
+type CleanedTranscript struct {
+	VideoID       string          `json:"video_id"`
+	Sentences     []CleanSentence `json:"sentences"`
+	PromptVersion string          `json:"prompt_version"`
+	SourceHash    string          `json:"source_hash"` // Hash of the segments that were cleaned
+	CleanedAt     time.Time       `json:"cleaned_at"`
+}
+
+var cleanupSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"sentences": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"text":           map[string]interface{}{"type": "string"},
+					"first_line":     map[string]interface{}{"type": "integer"},
+					"last_line":      map[string]interface{}{"type": "integer"},
+					"speaker_change": map[string]interface{}{"type": "boolean"},
+				},
+				"required":             []string{"text", "first_line", "last_line", "speaker_change"},
+				"additionalProperties": false,
+			},
+		},
+	},
+	"required":             []string{"sentences"},
+	"additionalProperties": false,
+}
+
+func cleanedTranscriptKey(videoID string) string {
+	return fmt.Sprintf("clean_transcript:%s", videoID)
+}
+
+func cleanupLockKey(videoID string) string {
+	return fmt.Sprintf("clean_transcript_lock:%s", videoID)
+}
+
+// ErrCleanupUnavailable is returned for transcripts that are never cleaned up: cleanup is disabled,
+// or the transcript is not an ingested auto-generated one
+var ErrCleanupUnavailable = errors.New("transcript is not cleaned up")
+
+// errTranscriptChanged is returned when a transcript is re-ingested while it is being cleaned
+var errTranscriptChanged = errors.New("transcript changed while it was cleaned")
+
+// segmentsHash fingerprints the segments a cleaned transcript is made from
+func segmentsHash(segments []TranscriptSegment) string {
+	data, _ := json.Marshal(segments)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// cleanChunk rewrites a batch of segments into sentences. The sentences must cover every line
+// exactly once and in order, otherwise the mapping back to timestamps would be lost.
+func cleanChunk(ctx context.Context, segments []TranscriptSegment, offset int, promptVersion string) ([]CleanSentence, error) {
+	type numberedLine struct {
+		Line int    `json:"line"`
+		Text string `json:"text"`
+	}
+	lines := make([]numberedLine, len(segments))
+	for i, segment := range segments {
+		lines[i] = numberedLine{Line: i, Text: segment.Text}
+	}
+	input, err := json.Marshal(lines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lines: %v", err)
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.TranscriptCleanupSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.TranscriptCleanup, map[string]interface{}{"Lines": string(input)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry once if the model does not keep the line mapping
+	for attempt := 0; attempt < 2; attempt++ {
+		response, err := CallGPTJSON(ctx, prompt, systemPrompt, "transcript_cleanup", cleanupSchema, 0.2, 12000)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Sentences []CleanSentence `json:"sentences"`
+		}
+		if err := json.Unmarshal([]byte(response), &result); err != nil {
+			return nil, fmt.Errorf("failed to parse cleaned transcript: %v", err)
+		}
+		if coversLines(result.Sentences, len(segments)) {
+			for i := range result.Sentences {
+				sentence := &result.Sentences[i]
+				first, last := segments[sentence.FirstLine], segments[sentence.LastLine]
+				sentence.Start = first.Start
+				sentence.End = last.Start + last.Duration
+				sentence.FirstLine += offset
+				sentence.LastLine += offset
+			}
+			return result.Sentences, nil
+		}
+		slog.WarnContext(ctx, "Cleanup did not map sentences to all lines, retrying", "lines", len(segments))
+	}
+	return nil, fmt.Errorf("cleanup did not preserve the line mapping")
+}
+
+// coversLines reports whether sentences cover lines 0 to n-1 contiguously and in order
+func coversLines(sentences []CleanSentence, n int) bool {
+	next := 0
+	for _, sentence := range sentences {
+		if sentence.FirstLine != next || sentence.LastLine < sentence.FirstLine || sentence.LastLine >= n {
+			return false
+		}
+		next = sentence.LastLine + 1
+	}
+	return next == n
+}
+
+// CleanTranscript restores punctuation, casing, sentences and speaker changes in a transcript.
+// Chunks the model cannot clean keep their original lines so one bad response does not lose the transcript.
+func CleanTranscript(ctx context.Context, videoID string, segments []TranscriptSegment, promptVersion string) (*CleanedTranscript, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("transcript is empty")
+	}
+
+	cleaned := &CleanedTranscript{VideoID: videoID, PromptVersion: promptVersion, CleanedAt: time.Now().UTC()}
+	for start := 0; start < len(segments); start += cleanupChunkSize {
+		end := start + cleanupChunkSize
+		if end > len(segments) {
+			end = len(segments)
+		}
+
+		sentences, err := cleanChunk(ctx, segments[start:end], start, promptVersion)
+		if err != nil {
+			slog.WarnContext(ctx, "Keeping original transcript lines", "video_id", videoID, "first_line", start, "last_line", end-1, "error", err)
+			for i, segment := range segments[start:end] {
+				sentences = append(sentences, CleanSentence{
+					Start:     segment.Start,
+					End:       segment.Start + segment.Duration,
+					Text:      segment.Text,
+					FirstLine: start + i,
+					LastLine:  start + i,
+				})
+			}
+		}
+		cleaned.Sentences = append(cleaned.Sentences, sentences...)
+	}
+	return cleaned, nil
+}
+
+// cachedCleanedTranscript returns the cleaned transcript cached for the prompt version and source
+// segments, or nil if there is none
+func cachedCleanedTranscript(ctx context.Context, videoID, promptVersion, sourceHash string) (*CleanedTranscript, error) {
+	val, err := RedisClient.Get(ctx, cleanedTranscriptKey(videoID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving cleaned transcript from Redis: %v", err)
+	}
+
+	var cached CleanedTranscript
+	if err := json.Unmarshal([]byte(val), &cached); err != nil || cached.PromptVersion != promptVersion || cached.SourceHash != sourceHash {
+		return nil, nil
+	}
+	return &cached, nil
+}
+
+// GetCleanedTranscript returns the cached cleaned transcript of a video. It never cleans while the
+// caller waits: if there is none yet, a background cleanup is scheduled and nil is returned.
+func GetCleanedTranscript(ctx context.Context, videoID string) (*CleanedTranscript, error) {
+	stored, err := GetStoredTranscript(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		transcript, err := GetTranscriptFromRedis(ctx, videoID)
+		if err != nil {
+			return nil, err
+		}
+		if transcript == "" {
+			return nil, ErrTranscriptNotFound
+		}
+		return nil, ErrCleanupUnavailable
+	}
+	if !config.TranscriptCleanup || !stored.AutoGenerated {
+		return nil, ErrCleanupUnavailable
+	}
+
+	cleaned, err := cachedCleanedTranscript(ctx, videoID, prompts.ResolveVersion(""), segmentsHash(stored.Segments))
+	if err != nil || cleaned != nil {
+		return cleaned, err
+	}
+	ScheduleCleanup(ctx, videoID)
+	return nil, nil
+}
+
+// cleanVideoTranscript returns the cached cleaned transcript of a video, cleaning and caching it if
+// there is none for the prompt version. Returns nil if the video has no transcript.
+func cleanVideoTranscript(ctx context.Context, videoID, promptVersion string) (*CleanedTranscript, error) {
+	segments, err := transcriptSegmentsForVideo(ctx, videoID)
+	if err != nil || segments == nil {
+		return nil, err
+	}
+	sourceHash := segmentsHash(segments)
+	if cached, err := cachedCleanedTranscript(ctx, videoID, promptVersion, sourceHash); err != nil || cached != nil {
+		return cached, err
+	}
+
+	cleaned, err := CleanTranscript(ctx, videoID, segments, promptVersion)
+	if err != nil {
+		return nil, err
+	}
+	cleaned.SourceHash = sourceHash
+
+	data, err := json.Marshal(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cleaned transcript: %v", err)
+	}
+
+	// Cleaning takes minutes; only cache the result if the transcript was not replaced meanwhile.
+	// Transcripts written by other services are stored under the video ID itself.
+	err = RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := transcriptSegmentsForVideo(ctx, videoID)
+		if err != nil {
+			return err
+		}
+		if segmentsHash(current) != sourceHash {
+			return errTranscriptChanged
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, cleanedTranscriptKey(videoID), data, 0)
+			return nil
+		})
+		return err
+	}, transcriptKey(videoID), videoID)
+	if errors.Is(err, errTranscriptChanged) || errors.Is(err, redis.TxFailedErr) {
+		return nil, errTranscriptChanged
+	} else if err != nil {
+		slog.WarnContext(ctx, "Failed to cache cleaned transcript", "video_id", videoID, "error", err)
+	}
+	slog.InfoContext(ctx, "Cleaned transcript", "video_id", videoID, "sentences", len(cleaned.Sentences))
+	return cleaned, nil
+}
+
+// ScheduleCleanup cleans an auto-generated transcript in the background when cleanup is enabled,
+// unless a cleanup of the video is already running. Prompts use the transcript as stored until the
+// cleaned one is cached; caches built from the uncleaned transcript are then dropped.
+func ScheduleCleanup(ctx context.Context, videoID string) {
+	if !config.TranscriptCleanup {
+		return
+	}
+	acquired, err := RedisClient.SetNX(ctx, cleanupLockKey(videoID), 1, cleanupTimeout).Result()
+	if err != nil || !acquired {
+		return
+	}
+
+	// The cleanup outlives the request that scheduled it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	go func() {
+		defer cancel()
+		defer RedisClient.Del(ctx, cleanupLockKey(videoID))
+
+		cleaned, err := cleanVideoTranscript(ctx, videoID, prompts.ResolveVersion(""))
+		if errors.Is(err, errTranscriptChanged) {
+			// The re-ingested transcript schedules its own cleanup once it is read
+			slog.InfoContext(ctx, "Dropped cleanup of a replaced transcript", "video_id", videoID)
+			return
+		}
+		if err != nil || cleaned == nil {
+			slog.WarnContext(ctx, "Failed to clean transcript", "video_id", videoID, "error", err)
+			return
+		}
+		invalidateDerivedCaches(ctx, videoID)
+	}()
+}
+
+// transcriptSegmentsForVideo returns the ingested segments of a video, or segments parsed from the
+// transcript written by other services. Returns nil if the video has no transcript.
+func transcriptSegmentsForVideo(ctx context.Context, videoID string) ([]TranscriptSegment, error) {
+	stored, err := GetStoredTranscript(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		return stored.Segments, nil
+	}
+
+	transcript, err := GetTranscriptFromRedis(ctx, videoID)
+	if err != nil || transcript == "" {
+		return nil, err
+	}
+	return transcriptSegments(transcript), nil
+}
+
+// Segments returns the sentences as timed segments, e.g. for captions
+func (c *CleanedTranscript) Segments() []TranscriptSegment {
+	segments := make([]TranscriptSegment, len(c.Sentences))
+	for i, sentence := range c.Sentences {
+		segments[i] = TranscriptSegment{Start: sentence.Start, Duration: sentence.End - sentence.Start, Text: sentence.Text}
+	}
+	return segments
+}
+
+// Render renders the sentences in the "seconds: text" transcript format, marking speaker changes
+// with ">>" as YouTube captions do
+func (c *CleanedTranscript) Render() string {
+	lines := make([]TranscriptLine, len(c.Sentences))
+	for i, sentence := range c.Sentences {
+		text := sentence.Text
+		if sentence.SpeakerChange {
+			text = ">> " + text
+		}
+		lines[i] = TranscriptLine{Start: strconv.FormatFloat(sentence.Start, 'f', 2, 64), Text: text}
+	}
+	return FormatTranscriptLines(lines)
+}
+
+// GetSourceTranscript returns the transcript prompts are built from: the cleaned transcript for
+// auto-generated transcripts once it is cached, otherwise the transcript as stored. An empty
+// transcript means none is stored.
+func GetSourceTranscript(ctx context.Context, videoID string) (string, error) {
+	transcript, _, err := sourceTranscript(ctx, videoID)
+	return transcript, err
+}
+
+// sourceTranscript returns the source transcript with the timed segments it was rendered from,
+// or nil segments when only the plain transcript is known
+func sourceTranscript(ctx context.Context, videoID string) (string, []TranscriptSegment, error) {
+	transcript, err := GetTranscriptFromRedis(ctx, videoID)
+	if err != nil || transcript == "" {
+		return "", nil, err
+	}
+	stored, err := GetStoredTranscript(ctx, videoID)
+	if err != nil {
+		return "", nil, err
+	}
+	var segments []TranscriptSegment
+	if stored != nil {
+		segments = stored.Segments
+	}
+
+	// Manually written captions are already punctuated, and transcripts from other services are
+	// not known to need it
+	if !config.TranscriptCleanup || stored == nil || !stored.AutoGenerated {
+		return transcript, segments, nil
+	}
+
+	// Cleaning takes minutes, so it never runs while a request waits
+	cleaned, err := cachedCleanedTranscript(ctx, videoID, prompts.ResolveVersion(""), segmentsHash(stored.Segments))
+	if err != nil || cleaned == nil {
+		if err != nil {
+			slog.WarnContext(ctx, "Using uncleaned transcript", "video_id", videoID, "error", err)
+		}
+		// Transcripts cleaned with an older prompt version, or ingested before cleanup was enabled
+		ScheduleCleanup(ctx, videoID)
+		return transcript, segments, nil
+	}
+	return cleaned.Render(), cleaned.Segments(), nil
+}
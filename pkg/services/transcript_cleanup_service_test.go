@@ -0,0 +1,60 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoversLines(t *testing.T) {
+	sentence := func(first, last int) CleanSentence {
+		return CleanSentence{FirstLine: first, LastLine: last}
+	}
+
+	tests := []struct {
+		name      string
+		sentences []CleanSentence
+		lines     int
+		want      bool
+	}{
+		{name: "one sentence per line", sentences: []CleanSentence{sentence(0, 0), sentence(1, 1), sentence(2, 2)}, lines: 3, want: true},
+		{name: "sentences spanning lines", sentences: []CleanSentence{sentence(0, 2), sentence(3, 4)}, lines: 5, want: true},
+		{name: "single sentence", sentences: []CleanSentence{sentence(0, 3)}, lines: 4, want: true},
+		{name: "no sentences and no lines", lines: 0, want: true},
+		{name: "no sentences", lines: 2, want: false},
+		{name: "first line skipped", sentences: []CleanSentence{sentence(1, 2)}, lines: 3, want: false},
+		{name: "gap between sentences", sentences: []CleanSentence{sentence(0, 0), sentence(2, 2)}, lines: 3, want: false},
+		{name: "overlapping sentences", sentences: []CleanSentence{sentence(0, 1), sentence(1, 2)}, lines: 3, want: false},
+		{name: "out of order", sentences: []CleanSentence{sentence(1, 1), sentence(0, 0)}, lines: 2, want: false},
+		{name: "last line missing", sentences: []CleanSentence{sentence(0, 1)}, lines: 3, want: false},
+		{name: "past the last line", sentences: []CleanSentence{sentence(0, 3)}, lines: 3, want: false},
+		{name: "reversed range", sentences: []CleanSentence{sentence(0, 0), sentence(1, 0)}, lines: 2, want: false},
+		{name: "negative line", sentences: []CleanSentence{sentence(-1, 1)}, lines: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coversLines(tt.sentences, tt.lines); got != tt.want {
+				t.Errorf("coversLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanedTranscriptRender(t *testing.T) {
+	cleaned := &CleanedTranscript{Sentences: []CleanSentence{
+		{Start: 0, End: 4.5, Text: "Welcome to the course.", FirstLine: 0, LastLine: 1},
+		{Start: 4.5, End: 7, Text: "Thanks for having me.", SpeakerChange: true, FirstLine: 2, LastLine: 2},
+	}}
+
+	if got, want := cleaned.Render(), "0.00: Welcome to the course.\n4.50: >> Thanks for having me.\n"; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	want := []TranscriptSegment{
+		{Start: 0, Duration: 4.5, Text: "Welcome to the course."},
+		{Start: 4.5, Duration: 2.5, Text: "Thanks for having me."},
+	}
+	if got := cleaned.Segments(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Segments() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Transcript formats accepted by IngestTranscript
+const (
+	FormatSegments = "segments"
+	FormatWebVTT   = "vtt"
+	FormatSRT      = "srt"
+)
+
+// TranscriptSegment is one timed piece of a transcript
+type TranscriptSegment struct {
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+	Text     string  `json:"text"`
+}
+
+// StoredTranscript is a normalized transcript with its metadata
+type StoredTranscript struct {
+	VideoID       string              `json:"video_id"`
+	Language      string              `json:"language,omitempty"`
+	Source        string              `json:"source,omitempty"` // e.g. youtube, upload, whisper
+	AutoGenerated bool                `json:"auto_generated"`
+	Segments      []TranscriptSegment `json:"segments"`
+	IngestedAt    time.Time           `json:"ingested_at"`
+}
+
+func transcriptKey(videoID string) string {
+	return fmt.Sprintf("transcript:%s", videoID)
+}
+
+var (
+	cueTimingPattern = regexp.MustCompile(`^(\S+)\s+-->\s+(\S+)`)
+	cueTagPattern    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseCueTimestamp parses WebVTT (00:01:02.500, 01:02.500) and SRT (00:01:02,500) timestamps into seconds
+func parseCueTimestamp(s string) (float64, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// ParseCaptions parses WebVTT or SRT content into segments. Both formats are cue blocks separated by
+// blank lines with a "start --> end" timing line, so one parser handles both.
+func ParseCaptions(content string) ([]TranscriptSegment, error) {
+	content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+
+	var segments []TranscriptSegment
+	for _, block := range strings.Split(content, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+
+		// Find the timing line; anything before it is a cue number or identifier
+		timing := -1
+		for i, line := range lines {
+			if cueTimingPattern.MatchString(strings.TrimSpace(line)) {
+				timing = i
+				break
+			}
+		}
+		if timing == -1 {
+			continue // WEBVTT header, NOTE, STYLE or REGION blocks
+		}
+
+		match := cueTimingPattern.FindStringSubmatch(strings.TrimSpace(lines[timing]))
+		start, err := parseCueTimestamp(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseCueTimestamp(match[2])
+		if err != nil {
+			return nil, err
+		}
+
+		text := cueTagPattern.ReplaceAllString(strings.Join(lines[timing+1:], " "), "")
+		segments = append(segments, TranscriptSegment{Start: start, Duration: end - start, Text: text})
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no caption cues found")
+	}
+	return segments, nil
+}
+
+// NormalizeSegments cleans segment text, drops empty segments, sorts by start time and removes the
+// repeated lines that rolling auto-generated captions produce.
+func NormalizeSegments(segments []TranscriptSegment) []TranscriptSegment {
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+
+	normalized := make([]TranscriptSegment, 0, len(segments))
+	for _, segment := range segments {
+		segment.Text = strings.Join(strings.Fields(segment.Text), " ")
+		if segment.Text == "" || segment.Start < 0 {
+			continue
+		}
+		if segment.Duration < 0 {
+			segment.Duration = 0
+		}
+
+		if n := len(normalized); n > 0 && normalized[n-1].Text == segment.Text {
+			// Extend the previous segment instead of repeating it
+			prev := &normalized[n-1]
+			if end := segment.Start + segment.Duration; end > prev.Start+prev.Duration {
+				prev.Duration = end - prev.Start
+			}
+			continue
+		}
+		normalized = append(normalized, segment)
+	}
+	return normalized
+}
+
+// RenderSegments renders segments in the "seconds: text" transcript format used in prompts
+func RenderSegments(segments []TranscriptSegment) string {
+	lines := make([]TranscriptLine, len(segments))
+	for i, segment := range segments {
+		lines[i] = TranscriptLine{Start: strconv.FormatFloat(segment.Start, 'f', 2, 64), Text: segment.Text}
+	}
+	return FormatTranscriptLines(lines)
+}
+
+// IngestTranscript normalizes and stores a transcript, replacing any previous one for the video
+func IngestTranscript(ctx context.Context, transcript *StoredTranscript) error {
+	transcript.Segments = NormalizeSegments(transcript.Segments)
+	if len(transcript.Segments) == 0 {
+		return fmt.Errorf("transcript has no text")
+	}
+	transcript.Language = NormalizeLanguage(transcript.Language)
+	transcript.IngestedAt = time.Now().UTC()
+	ScanTranscript(ctx, transcript.VideoID, RenderSegments(transcript.Segments))
+
+	data, err := json.Marshal(transcript)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %v", err)
+	}
+	if err := RedisClient.Set(ctx, transcriptKey(transcript.VideoID), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store transcript in Redis: %v", err)
+	}
+
+	invalidateTranscriptCaches(ctx, transcript.VideoID)
+	if transcript.AutoGenerated {
+		ScheduleCleanup(ctx, transcript.VideoID)
+	}
+
+	// A declared language saves a detection call later
+	if transcript.Language != "" {
+		if err := RedisClient.Set(ctx, "transcript_language:"+transcript.VideoID, transcript.Language, 168*time.Hour).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to store transcript language", "video_id", transcript.VideoID, "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Ingested transcript", "video_id", transcript.VideoID, "segments", len(transcript.Segments))
+	return nil
+}
+
+// invalidateTranscriptCaches drops everything derived from a video's previous transcript
+func invalidateTranscriptCaches(ctx context.Context, videoID string) {
+	keys := []string{"transcript_language:" + videoID, embeddingsKey(videoID), cleanedTranscriptKey(videoID)}
+	if err := RedisClient.Del(ctx, keys...).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to invalidate caches", "video_id", videoID, "error", err)
+	}
+	if err := RedisClient.HDel(ctx, embeddingCodesKey, videoID).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to unregister embeddings", "video_id", videoID, "error", err)
+	}
+	invalidateDerivedCaches(ctx, videoID)
+}
+
+// derivedKeysKey holds the cache keys generated from a video's transcript, in every tenant, so
+// they can be dropped without scanning the keyspace
+func derivedKeysKey(videoID string) string {
+	return "derived_keys:" + videoID
+}
+
+// storeDerived caches content generated from a video's transcript and records its key, so that a
+// new or cleaned transcript drops it
+func storeDerived(ctx context.Context, videoID, key string, data []byte, ttl time.Duration) error {
+	_, err := RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, ttl)
+		pipe.SAdd(ctx, derivedKeysKey(videoID), key)
+		pipe.Expire(ctx, derivedKeysKey(videoID), ttl)
+		return nil
+	})
+	return err
+}
+
+// invalidateDerivedCaches drops the generated content built from a video's transcript in every tenant
+func invalidateDerivedCaches(ctx context.Context, videoID string) {
+	keys, err := RedisClient.SMembers(ctx, derivedKeysKey(videoID)).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list cached transcript data", "video_id", videoID, "error", err)
+		return
+	}
+
+	keys = append(keys, derivedKeysKey(videoID))
+	if err := RedisClient.Del(ctx, keys...).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to invalidate caches", "video_id", videoID, "error", err)
+	}
+}
+
+// GetStoredTranscript returns the normalized transcript of a video, or nil if none was ingested
+func GetStoredTranscript(ctx context.Context, videoID string) (*StoredTranscript, error) {
+	val, err := RedisClient.Get(ctx, transcriptKey(videoID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving transcript from Redis: %v", err)
+	}
+
+	var transcript StoredTranscript
+	if err := json.Unmarshal([]byte(val), &transcript); err != nil {
+		return nil, fmt.Errorf("failed to decode transcript: %v", err)
+	}
+	return &transcript, nil
+}
+
+// GetTranscriptDuration returns where the last segment of a video's transcript ends, in seconds,
+// or 0 if the video has no transcript. Videos can run on past their last caption.
+func GetTranscriptDuration(ctx context.Context, videoID string) (float64, error) {
+	segments, err := transcriptSegmentsForVideo(ctx, videoID)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+	last := segments[len(segments)-1]
+	return last.Start + last.Duration, nil
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Number of transcript lines sent to the model per translation request
+const translationChunkSize = 40
+
+// TranscriptLine is a single "seconds: text" line of a transcript
+type TranscriptLine struct {
+	Start string
+	Text  string
+}
+
+// ParseTranscriptLines splits a transcript into its timestamped lines. Lines without a
+// leading timestamp are kept with an empty Start.
+func ParseTranscriptLines(transcript string) []TranscriptLine {
+	var lines []TranscriptLine
+	for _, raw := range strings.Split(transcript, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		line := TranscriptLine{Text: raw}
+		if idx := strings.Index(raw, ":"); idx > 0 && isTimestamp(raw[:idx]) {
+			line.Start = raw[:idx]
+			line.Text = strings.TrimSpace(raw[idx+1:])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func isTimestamp(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatTranscriptLines renders lines back into the "seconds: text" transcript format
+func FormatTranscriptLines(lines []TranscriptLine) string {
+	var sb strings.Builder
+	for _, line := range lines {
+		if line.Start != "" {
+			sb.WriteString(line.Start + ": ")
+		}
+		sb.WriteString(line.Text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+var translationSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"lines": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string"},
+		},
+	},
+	"required":             []string{"lines"},
+	"additionalProperties": false,
+}
+
+// translateChunk translates a batch of lines, returning exactly one translated line per input line
+func translateChunk(ctx context.Context, texts []string, lang, promptVersion string) ([]string, error) {
+	input, err := json.Marshal(texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lines: %v", err)
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.TranslationSystem, map[string]interface{}{"Language": LanguageName(lang)})
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.Translation, map[string]interface{}{"Lines": string(input)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry once if the model does not keep the line alignment
+	for attempt := 0; attempt < 2; attempt++ {
+		response, err := CallGPTJSON(ctx, prompt, systemPrompt, "transcript_translation", translationSchema, 0.2, 8000)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Lines []string `json:"lines"`
+		}
+		if err := json.Unmarshal([]byte(response), &result); err != nil {
+			return nil, fmt.Errorf("failed to parse translation: %v", err)
+		}
+		if len(result.Lines) == len(texts) {
+			return result.Lines, nil
+		}
+		slog.WarnContext(ctx, "Translation did not keep the line alignment, retrying", "lines", len(texts), "returned", len(result.Lines))
+	}
+	return nil, fmt.Errorf("translation did not preserve line alignment")
+}
+
+// TranslateTranscript translates a transcript chunk by chunk, keeping every line's timestamp
+func TranslateTranscript(ctx context.Context, transcript, lang, promptVersion string) (string, error) {
+	lines := ParseTranscriptLines(transcript)
+	for start := 0; start < len(lines); start += translationChunkSize {
+		end := start + translationChunkSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		texts := make([]string, 0, end-start)
+		for _, line := range lines[start:end] {
+			texts = append(texts, line.Text)
+		}
+
+		translated, err := translateChunk(ctx, texts, lang, promptVersion)
+		if err != nil {
+			return "", fmt.Errorf("failed to translate lines %d-%d: %v", start, end-1, err)
+		}
+		for i, text := range translated {
+			lines[start+i].Text = text
+		}
+	}
+	return FormatTranscriptLines(lines), nil
+}
+
+func translatedTranscriptKey(ctx context.Context, lang, videoID string) string {
+	return tenantKey(ctx, "translation", lang+":"+videoID)
+}
+
+// CachedTranslation is a translated transcript together with the prompt version and source it was made from
+type CachedTranslation struct {
+	Transcript    string `json:"transcript"`
+	PromptVersion string `json:"prompt_version"`
+	SourceHash    string `json:"source_hash"`
+}
+
+// GetTranslatedTranscript returns the transcript in the target language. Translations are done
+// once per video, language and prompt version and reused from Redis afterwards, as long as the
+// source transcript has not changed since, e.g. by being cleaned up.
+func GetTranslatedTranscript(ctx context.Context, videoID, transcript, lang string) (string, error) {
+	// Without the source language the transcript may or may not need translating, so fail
+	// rather than pass it off as a translation
+	sourceLang, err := GetTranscriptLanguage(ctx, videoID, transcript)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect transcript language: %w", err)
+	}
+	if sourceLang == lang {
+		return transcript, nil
+	}
+
+	promptVersion := prompts.ResolveVersion("")
+	sourceHash := fmt.Sprintf("%x", sha256.Sum256([]byte(transcript)))
+	key := translatedTranscriptKey(ctx, lang, videoID)
+	val, err := RedisClient.Get(ctx, key).Result()
+	if err == nil {
+		var cached CachedTranslation
+		if err := json.Unmarshal([]byte(val), &cached); err == nil && cached.PromptVersion == promptVersion && cached.SourceHash == sourceHash {
+			return cached.Transcript, nil
+		}
+	} else if err != redis.Nil {
+		return "", fmt.Errorf("error retrieving translated transcript from Redis: %v", err)
+	}
+
+	translated, err := TranslateTranscript(ctx, transcript, lang, promptVersion)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(CachedTranslation{Transcript: translated, PromptVersion: promptVersion, SourceHash: sourceHash})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal translation: %v", err)
+	}
+	if err := storeDerived(ctx, videoID, key, data, 168*time.Hour); err != nil {
+		slog.WarnContext(ctx, "Failed to cache translated transcript", "video_id", videoID, "error", err)
+	}
+	slog.InfoContext(ctx, "Translated transcript", "video_id", videoID, "from", sourceLang, "to", lang)
+	return translated, nil
+}
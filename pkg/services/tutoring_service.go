@@ -0,0 +1,149 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TutoringState tracks the question a learner is being guided through
+type TutoringState struct {
+	Question string `json:"question"`
+	Hints    int    `json:"hints"`
+}
+
+// TutoringOptions controls how a tutoring-mode message is handled
+type TutoringOptions struct {
+	NewQuestion  bool // Treat the message as a new question even if one is in progress
+	RevealAnswer bool // The learner asked for the full answer
+}
+
+// TutoringResult is the assistant's reply in tutoring mode
+type TutoringResult struct {
+	Answer         string `json:"answer"`
+	HintsGiven     int    `json:"hints_given"`
+	MaxHints       int    `json:"max_hints"`
+	AnswerRevealed bool   `json:"answer_revealed"`
+}
+
+func tutoringKey(ctx context.Context, assistantID string) string {
+	return tenantKey(ctx, "tutoring", assistantID)
+}
+
+// GetTutoringState returns the in-progress tutoring question for an assistant session, or nil if there is none
+func GetTutoringState(ctx context.Context, assistantID string) (*TutoringState, error) {
+	val, err := RedisClient.Get(ctx, tutoringKey(ctx, assistantID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving tutoring state from Redis: %v", err)
+	}
+
+	var state TutoringState
+	if err := json.Unmarshal([]byte(val), &state); err != nil {
+		return nil, fmt.Errorf("failed to decode tutoring state: %v", err)
+	}
+	return &state, nil
+}
+
+func storeTutoringState(ctx context.Context, assistantID string, state *TutoringState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tutoring state: %v", err)
+	}
+	return RedisClient.Set(ctx, tutoringKey(ctx, assistantID), data, 24*time.Hour).Err()
+}
+
+// AskTutoringQuestion answers in tutoring mode: the learner gets guiding questions and hints,
+// and the full answer only when they ask for it or after the configured number of hints.
+func AskTutoringQuestion(ctx context.Context, videoID, assistantID, message string, timestamp int, opts QuestionOptions, tutoring TutoringOptions) (*TutoringResult, error) {
+	state, err := GetTutoringState(ctx, assistantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Either start a new question or treat the message as an attempt at the current one
+	var prompt string
+	if state == nil || tutoring.NewQuestion {
+		state = &TutoringState{Question: message}
+		prompt, err = createPrompt(opts.PromptVersion, message, timestamp, len(opts.Frame) > 0, opts.Language)
+	} else {
+		prompt, err = prompts.Render(opts.PromptVersion, prompts.TutoringAttempt, map[string]interface{}{
+			"Timestamp": timestamp,
+			"Question":  state.Question,
+			"Attempt":   message,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	languageName := ""
+	if opts.Language != "" {
+		languageName = LanguageName(opts.Language)
+	}
+
+	// The persona shapes the tone of the hints; tutoring mode decides what they reveal
+	persona := ""
+	if opts.Persona != "" {
+		if persona, err = prompts.Render(opts.PromptVersion, prompts.Persona(opts.Persona), nil); err != nil {
+			return nil, err
+		}
+	}
+	// Match glossary terms against the original question as well as the learner's latest attempt
+	glossary := GlossaryContext(ctx, videoID, state.Question+"\n"+message, opts.Language, opts.PromptVersion)
+
+	reveal := tutoring.RevealAnswer || state.Hints >= config.TutoringMaxHints
+	var instructions string
+	if reveal {
+		instructions, err = prompts.Render(opts.PromptVersion, prompts.TutoringReveal, map[string]interface{}{
+			"Language": languageName,
+			"Persona":  persona,
+			"Glossary": glossary,
+		})
+	} else {
+		instructions, err = prompts.Render(opts.PromptVersion, prompts.TutoringHint, map[string]interface{}{
+			"HintNumber": state.Hints + 1,
+			"MaxHints":   config.TutoringMaxHints,
+			"Language":   languageName,
+			"Persona":    persona,
+			"Glossary":   glossary,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	guardrails, err := prompts.Render(opts.PromptVersion, prompts.Guardrails, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := askThread(ctx, videoID, assistantID, prompt, timestamp, opts.Frame, instructions+"\n\n"+guardrails)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TutoringResult{Answer: answer, MaxHints: config.TutoringMaxHints, AnswerRevealed: reveal}
+	if reveal {
+		result.HintsGiven = state.Hints
+		if err := RedisClient.Del(ctx, tutoringKey(ctx, assistantID)).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to clear tutoring state", "assistant_id", assistantID, "error", err)
+		}
+		return result, nil
+	}
+
+	state.Hints++
+	result.HintsGiven = state.Hints
+	if err := storeTutoringState(ctx, assistantID, state); err != nil {
+		return nil, fmt.Errorf("failed to store tutoring state in Redis: %v", err)
+	}
+	slog.InfoContext(ctx, "Gave tutoring hint", "assistant_id", assistantID, "hint", state.Hints, "max_hints", config.TutoringMaxHints)
+	return result, nil
+}
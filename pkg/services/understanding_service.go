@@ -0,0 +1,203 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Seconds of transcript on each side of the question's timestamp used for understanding checks
+const understandingWindowSeconds = 90
+
+// UnderstandingCheck is a check-for-understanding question waiting for the learner's reply
+type UnderstandingCheck struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+}
+
+// storedCheck is what is kept in Redis for a check, including the parts hidden from the learner
+type storedCheck struct {
+	Question       string `json:"question"`
+	ExpectedPoints string `json:"expected_points"`
+	Window         string `json:"window"`
+	Language       string `json:"language"`
+}
+
+// UnderstandingEvaluation is the short evaluation of a learner's reply to a check
+type UnderstandingEvaluation struct {
+	Understood bool   `json:"understood"`
+	Feedback   string `json:"feedback"`
+}
+
+func understandingCheckKey(ctx context.Context, assistantID, checkID string) string {
+	return tenantKey(ctx, "understanding_check", assistantID+":"+checkID)
+}
+
+// TranscriptWindow returns the transcript lines within radius seconds of timestamp
+func TranscriptWindow(transcript string, timestamp, radius int) string {
+	var window []TranscriptLine
+	for _, line := range ParseTranscriptLines(transcript) {
+		start, err := strconv.ParseFloat(line.Start, 64)
+		if err != nil {
+			continue
+		}
+		if start >= float64(timestamp-radius) && start <= float64(timestamp+radius) {
+			window = append(window, line)
+		}
+	}
+	return FormatTranscriptLines(window)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var understandingCheckSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"question":        map[string]interface{}{"type": "string"},
+		"expected_points": map[string]interface{}{"type": "string"},
+	},
+	"required":             []string{"question", "expected_points"},
+	"additionalProperties": false,
+}
+
+var understandingEvaluationSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"understood": map[string]interface{}{"type": "boolean"},
+		"feedback":   map[string]interface{}{"type": "string"},
+	},
+	"required":             []string{"understood", "feedback"},
+	"additionalProperties": false,
+}
+
+// GenerateUnderstandingCheck creates a check-for-understanding question for an answer, tied to the
+// transcript around the question's timestamp, and stores it until the learner replies.
+func GenerateUnderstandingCheck(ctx context.Context, videoID, assistantID, question, answer string, timestamp int, opts QuestionOptions) (*UnderstandingCheck, error) {
+	transcript, err := GetTranscriptFromRedis(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	window := TranscriptWindow(transcript, timestamp, understandingWindowSeconds)
+
+	languageName := ""
+	if opts.Language != "" {
+		languageName = LanguageName(opts.Language)
+	}
+
+	systemPrompt, err := prompts.Render(opts.PromptVersion, prompts.UnderstandingCheckSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(opts.PromptVersion, prompts.UnderstandingCheck, map[string]interface{}{
+		"Question": question,
+		"Answer":   answer,
+		"Window":   window,
+		"Language": languageName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := CallGPTJSON(ctx, prompt, systemPrompt, "understanding_check", understandingCheckSchema, 0.5, 1000)
+	if err != nil {
+		return nil, fmt.Errorf("GPT call failed: %v", err)
+	}
+
+	var stored storedCheck
+	if err := json.Unmarshal([]byte(response), &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse understanding check: %v", err)
+	}
+	stored.Window = window
+	stored.Language = languageName
+
+	checkID, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate check ID: %v", err)
+	}
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal understanding check: %v", err)
+	}
+	if err := RedisClient.Set(ctx, understandingCheckKey(ctx, assistantID, checkID), data, 24*time.Hour).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store understanding check in Redis: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Generated understanding check", "check_id", checkID, "assistant_id", assistantID)
+	return &UnderstandingCheck{ID: checkID, Question: stored.Question}, nil
+}
+
+// EvaluateUnderstanding evaluates a learner's reply to a check and records both in the session history
+func EvaluateUnderstanding(ctx context.Context, assistantID, checkID, reply, promptVersion string) (*UnderstandingEvaluation, error) {
+	key := understandingCheckKey(ctx, assistantID, checkID)
+	val, err := RedisClient.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving understanding check from Redis: %v", err)
+	}
+
+	var check storedCheck
+	if err := json.Unmarshal([]byte(val), &check); err != nil {
+		return nil, fmt.Errorf("failed to decode understanding check: %v", err)
+	}
+
+	systemPrompt, err := prompts.Render(promptVersion, prompts.UnderstandingEvaluationSystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.UnderstandingEvaluation, map[string]interface{}{
+		"Question":       check.Question,
+		"ExpectedPoints": check.ExpectedPoints,
+		"Window":         check.Window,
+		"Reply":          reply,
+		"Language":       check.Language,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := CallGPTJSON(ctx, prompt, systemPrompt, "understanding_evaluation", understandingEvaluationSchema, 0.3, 1000)
+	if err != nil {
+		return nil, fmt.Errorf("GPT call failed: %v", err)
+	}
+
+	var evaluation UnderstandingEvaluation
+	if err := json.Unmarshal([]byte(response), &evaluation); err != nil {
+		return nil, fmt.Errorf("failed to parse evaluation: %v", err)
+	}
+
+	// ✅ Store the check, the reply and the evaluation in the session history
+	interactionKey := interactionsKey(ctx, assistantID)
+	err = RedisClient.RPush(ctx, interactionKey,
+		"Check: "+check.Question,
+		"User: "+reply,
+		fmt.Sprintf("Evaluation: understood=%t. %s", evaluation.Understood, evaluation.Feedback),
+	).Err()
+	if err == nil {
+		err = RedisClient.Expire(ctx, interactionKey, 168*time.Hour).Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to store evaluation", "assistant_id", assistantID, "error", err)
+		return nil, fmt.Errorf("failed to store evaluation in Redis: %v", err)
+	}
+
+	if err := RedisClient.Del(ctx, key).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to delete understanding check", "check_id", checkID, "error", err)
+	}
+	return &evaluation, nil
+}
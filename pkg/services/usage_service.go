@@ -0,0 +1,297 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/config"
+	"Learning-Mode-AI-Ai-Service/pkg/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Dimensions usage is aggregated by
+const (
+	UsageByUser     = "user"
+	UsageByVideo    = "video"
+	UsageByEndpoint = "endpoint"
+	UsageByModel    = "model"
+)
+
+// Retention of usage aggregates; monthly totals only need to outlive the month they budget
+const (
+	usageDailyTTL   = 90 * 24 * time.Hour
+	usageMonthlyTTL = 62 * 24 * time.Hour
+)
+
+// Usage is the token usage block of an OpenAI response
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// Built-in prices per million tokens; MODEL_PRICES entries override these
+var defaultModelPrices = map[string]string{
+	"gpt-4o-mini":            "0.15/0.60",
+	"gpt-4o":                 "2.50/10.00",
+	"text-embedding-3-small": "0.02/0",
+	"text-embedding-3-large": "0.13/0",
+}
+
+// UsageTotals are aggregated usage figures
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// UsageReportRow is the usage of one user, video, endpoint or model over a report's date range
+type UsageReportRow struct {
+	Key string `json:"key"`
+	UsageTotals
+}
+
+// Budget is a user's spend against their monthly budget. A zero Limit means no budget.
+type Budget struct {
+	Month    string  `json:"month"`
+	Plan     string  `json:"plan"`
+	SpentUSD float64 `json:"spent_usd"`
+	LimitUSD float64 `json:"limit_usd"`
+	Exceeded bool    `json:"exceeded"`
+}
+
+func usageKey(ctx context.Context, day, dimension, value string) string {
+	return tenantKey(ctx, "usage", day+":"+dimension+":"+value)
+}
+
+func usageIndexKey(ctx context.Context, day, dimension string) string {
+	return tenantKey(ctx, "usage_index", day+":"+dimension)
+}
+
+func monthlyUsageKey(ctx context.Context, month, userID string) string {
+	return tenantKey(ctx, "usage_month", month+":"+userID)
+}
+
+// parseModelPrice parses "0.15/0.60" into input and output prices
+func parseModelPrice(s string) (ModelPrice, error) {
+	input, output, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return ModelPrice{}, fmt.Errorf("invalid price %q, expected <input>/<output>", s)
+	}
+	var price ModelPrice
+	var err error
+	if price.Input, err = strconv.ParseFloat(input, 64); err != nil {
+		return ModelPrice{}, fmt.Errorf("invalid input price in %q", s)
+	}
+	if price.Output, err = strconv.ParseFloat(output, 64); err != nil {
+		return ModelPrice{}, fmt.Errorf("invalid output price in %q", s)
+	}
+	return price, nil
+}
+
+// priceFor returns the price of a model. Responses name dated snapshots such as
+// gpt-4o-mini-2024-07-18, so the longest configured prefix wins.
+func priceFor(model string) (ModelPrice, bool) {
+	best := ""
+	value := ""
+	for _, prices := range []map[string]string{defaultModelPrices, config.ModelPrices} {
+		for name, v := range prices {
+			if strings.HasPrefix(model, name) && len(name) >= len(best) {
+				best, value = name, v
+			}
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+
+	price, err := parseModelPrice(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid model price", "model", best, "error", err)
+		return ModelPrice{}, false
+	}
+	return price, true
+}
+
+// usageCostMicros returns the cost of usage in millionths of a USD
+func usageCostMicros(model string, usage Usage) int64 {
+	price, ok := priceFor(model)
+	if !ok {
+		slog.Warn("No price configured for model, recording its usage at no cost", "model", model)
+		return 0
+	}
+	// Prices are per million tokens, so tokens * price is already in micro-USD
+	return int64(math.Round(float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output))
+}
+
+// recordResponseUsage records the usage block of an OpenAI response, if it has one
+func recordResponseUsage(ctx context.Context, body []byte) {
+	var resp struct {
+		Model string `json:"model"`
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Usage == nil {
+		return
+	}
+	if resp.Usage.PromptTokens == 0 && resp.Usage.CompletionTokens == 0 {
+		return
+	}
+	RecordUsage(ctx, resp.Model, *resp.Usage)
+}
+
+// RecordUsage adds model usage to the daily aggregates of the caller's user, video and endpoint and
+// of the model within the caller's tenant, to the user's monthly spend and to the token metrics. Failures are logged, not returned, so accounting
+// never fails a request whose tokens are already spent.
+func RecordUsage(ctx context.Context, model string, usage Usage) {
+	// The tokens are spent even if the request was cancelled meanwhile
+	ctx = context.WithoutCancel(ctx)
+	caller := CallerFromContext(ctx)
+	cost := usageCostMicros(model, usage)
+	metrics.Tokens(caller.Endpoint, model, usage.PromptTokens, usage.CompletionTokens, float64(cost)/1e6)
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+
+	dimensions := map[string]string{
+		UsageByUser:     caller.UserID,
+		UsageByVideo:    caller.VideoID,
+		UsageByEndpoint: caller.Endpoint,
+		UsageByModel:    model,
+	}
+
+	pipe := RedisClient.TxPipeline()
+	for dimension, value := range dimensions {
+		if value == "" {
+			value = "unknown"
+		}
+		key := usageKey(ctx, day, dimension, value)
+		pipe.HIncrBy(ctx, key, "requests", 1)
+		pipe.HIncrBy(ctx, key, "prompt_tokens", int64(usage.PromptTokens))
+		pipe.HIncrBy(ctx, key, "completion_tokens", int64(usage.CompletionTokens))
+		pipe.HIncrBy(ctx, key, "cost_micros", cost)
+		pipe.Expire(ctx, key, usageDailyTTL)
+		pipe.SAdd(ctx, usageIndexKey(ctx, day, dimension), value)
+		pipe.Expire(ctx, usageIndexKey(ctx, day, dimension), usageDailyTTL)
+	}
+	if caller.UserID != "" {
+		key := monthlyUsageKey(ctx, now.Format("2006-01"), caller.UserID)
+		pipe.HIncrBy(ctx, key, "cost_micros", cost)
+		pipe.Expire(ctx, key, usageMonthlyTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to record usage", "model", model, "error", err)
+	}
+}
+
+// monthlyBudgetUSD returns the monthly budget of a plan, or 0 if it has none
+func monthlyBudgetUSD(plan string) float64 {
+	value, ok := config.MonthlyBudgets[plan]
+	if !ok {
+		return 0
+	}
+	budget, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("Ignoring invalid monthly budget", "plan", plan, "error", err)
+		return 0
+	}
+	return budget
+}
+
+// GetBudget returns a user's spend this month against the budget of their plan
+func GetBudget(ctx context.Context, userID string) (*Budget, error) {
+	plan := GetUserPlan(ctx, userID)
+	month := time.Now().UTC().Format("2006-01")
+	budget := &Budget{Month: month, Plan: plan, LimitUSD: monthlyBudgetUSD(plan)}
+
+	micros, err := RedisClient.HGet(ctx, monthlyUsageKey(ctx, month, userID), "cost_micros").Int64()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error retrieving monthly usage from Redis: %v", err)
+	}
+	budget.SpentUSD = float64(micros) / 1e6
+	budget.Exceeded = budget.LimitUSD > 0 && budget.SpentUSD >= budget.LimitUSD
+	return budget, nil
+}
+
+// CheckBudget returns ErrBudgetExceeded, instead of a model being called, if the caller's user has
+// spent their monthly budget. Anonymous callers and Redis failures are let through.
+func CheckBudget(ctx context.Context) error {
+	userID := CallerFromContext(ctx).UserID
+	if userID == "" {
+		return nil
+	}
+	budget, err := GetBudget(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Budget check skipped", "error", err)
+		return nil
+	}
+	if budget.Exceeded {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// GetUsageReport aggregates the caller's tenant's daily usage between two dates (inclusive) by a
+// dimension, most expensive first. With a key, only that user, video, endpoint or model is reported.
+func GetUsageReport(ctx context.Context, from, to time.Time, dimension, key string) ([]UsageReportRow, *UsageTotals, error) {
+	totals := make(map[string]*UsageTotals)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+
+		values := []string{key}
+		if key == "" {
+			var err error
+			if values, err = RedisClient.SMembers(ctx, usageIndexKey(ctx, date, dimension)).Result(); err != nil {
+				return nil, nil, fmt.Errorf("error retrieving usage index from Redis: %v", err)
+			}
+		}
+
+		for _, value := range values {
+			fields, err := RedisClient.HGetAll(ctx, usageKey(ctx, date, dimension, value)).Result()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error retrieving usage from Redis: %v", err)
+			}
+			if len(fields) == 0 {
+				continue
+			}
+
+			row, ok := totals[value]
+			if !ok {
+				row = &UsageTotals{}
+				totals[value] = row
+			}
+			row.Requests += parseCount(fields["requests"])
+			row.PromptTokens += parseCount(fields["prompt_tokens"])
+			row.CompletionTokens += parseCount(fields["completion_tokens"])
+			row.CostUSD += float64(parseCount(fields["cost_micros"])) / 1e6
+		}
+	}
+
+	rows := make([]UsageReportRow, 0, len(totals))
+	sum := &UsageTotals{}
+	for value, row := range totals {
+		rows = append(rows, UsageReportRow{Key: value, UsageTotals: *row})
+		sum.Requests += row.Requests
+		sum.PromptTokens += row.PromptTokens
+		sum.CompletionTokens += row.CompletionTokens
+		sum.CostUSD += row.CostUSD
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CostUSD > rows[j].CostUSD })
+	return rows, sum, nil
+}
+
+func parseCount(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
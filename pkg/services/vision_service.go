@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Keys the capture service writes frames under. Only these can be read through a question's
+// frame_key, so a client cannot make the service read back arbitrary Redis keys.
+var frameKeyPattern = regexp.MustCompile(`^frame:[A-Za-z0-9_-]+(:[A-Za-z0-9_-]+)*$`)
+
+const maxFrameKeyLength = 200
+
+// ValidFrameKey reports whether key is a frame key written by the capture service
+func ValidFrameKey(key string) bool {
+	return len(key) <= maxFrameKeyLength && frameKeyPattern.MatchString(key)
+}
+
+// LoadFrame resolves the frame attached to a question. The frame is either sent inline
+// as base64 (optionally as a data URL) or written to Redis by the capture service. Either way it
+// must pass CheckFrame, as ingested frames do, before it is uploaded.
+func LoadFrame(ctx context.Context, frameData, frameKey string) ([]byte, error) {
+	frame, err := resolveFrame(ctx, frameData, frameKey)
+	if err != nil || frame == nil {
+		return nil, err
+	}
+	if err := CheckFrame(frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func resolveFrame(ctx context.Context, frameData, frameKey string) ([]byte, error) {
+	if frameData != "" {
+		return decodeFrame(frameData)
+	}
+	if frameKey == "" {
+		return nil, nil
+	}
+	if !ValidFrameKey(frameKey) {
+		return nil, fmt.Errorf("invalid frame key")
+	}
+
+	val, err := RedisClient.Get(ctx, frameKey).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("frame not found in Redis for key: %s", frameKey)
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving frame from Redis: %v", err)
+	}
+
+	// The capture service may store either raw image bytes or a base64 string
+	if frame, err := decodeFrame(string(val)); err == nil {
+		return frame, nil
+	}
+	return val, nil
+}
+
+func decodeFrame(data string) ([]byte, error) {
+	if idx := strings.Index(data, ","); strings.HasPrefix(data, "data:") && idx != -1 {
+		data = data[idx+1:]
+	}
+	frame, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode frame: %v", err)
+	}
+	return frame, nil
+}
+
+// CheckEncodedFrame checks a base64-encoded frame with CheckFrame
+func CheckEncodedFrame(data string) error {
+	frame, err := decodeFrame(data)
+	if err != nil {
+		return fmt.Errorf("must be base64-encoded")
+	}
+	return CheckFrame(frame)
+}
+
+// frameExtension sniffs the image type so the upload carries a sensible filename.
+func frameExtension(frame []byte) string {
+	switch http.DetectContentType(frame) {
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	default:
+		return "jpg"
+	}
+}
+
+// UploadFrame uploads a video frame to OpenAI with the vision purpose so it can be
+// referenced as image content in a thread message. Callers delete it with DeleteFrame
+// once the run that uses it has finished.
+func UploadFrame(ctx context.Context, frame []byte, videoID string, timestamp int) (string, error) {
+	filename := fmt.Sprintf("%s_%d.%s", videoID, timestamp, frameExtension(frame))
+	fileID, err := UploadFile(ctx, "vision", filename, frame)
+	if err != nil {
+		return "", err
+	}
+
+	slog.InfoContext(ctx, "Uploaded frame", "video_id", videoID, "timestamp", timestamp, "file_id", fileID)
+	return fileID, nil
+}
+
+// DeleteFrame deletes an uploaded frame. It runs after the request may have been cancelled,
+// so it does not inherit the request's cancellation.
+func DeleteFrame(ctx context.Context, fileID string) {
+	if err := DeleteFile(context.WithoutCancel(ctx), fileID); err != nil {
+		slog.WarnContext(ctx, "Failed to delete frame", "file_id", fileID, "error", err)
+	}
+}
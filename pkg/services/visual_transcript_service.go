@@ -0,0 +1,334 @@
+package services
+
+import (
+	"Learning-Mode-AI-Ai-Service/pkg/prompts"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Frames whose perceptual hashes differ by at most this many bits are treated as the same slide
+const frameDuplicateThreshold = 6
+
+// Attempts at merging a batch into the visual transcript while other batches of the video are stored
+const visualTranscriptMergeAttempts = 5
+
+// Largest frame decoded for hashing, in pixels. Image headers can declare dimensions far beyond
+// their file size, so frames are checked before they are decoded.
+const maxFramePixels = 4096 * 4096
+
+type TimestampedFrame struct {
+	Timestamp int    `json:"timestamp"`
+	Frame     string `json:"frame"` // Base64-encoded image
+}
+
+// VisualSegment is one entry of the visual transcript: what was on screen starting at Timestamp
+type VisualSegment struct {
+	Timestamp   int    `json:"timestamp"`
+	Text        string `json:"text"`
+	Description string `json:"description"`
+	Hash        uint64 `json:"hash"`
+	// Prompt version the content was extracted with. Frames are not kept, so older segments are not re-extracted.
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// FrameFailure is a frame that could not be ingested. Other frames of the batch are still ingested.
+type FrameFailure struct {
+	Timestamp int    `json:"timestamp"`
+	Error     string `json:"error"`
+}
+
+func visualTranscriptKey(videoID string) string {
+	return "visual_transcript:" + videoID
+}
+
+// IngestFrames deduplicates the frames, extracts on-screen text from each distinct frame
+// and merges the result into the visual transcript stored in Redis. Frames that fail are skipped
+// and reported; the frames extracted before a failure or cancellation are still stored, so the
+// vision calls already paid for are not lost.
+func IngestFrames(ctx context.Context, videoID string, frames []TimestampedFrame) ([]VisualSegment, []FrameFailure, error) {
+	existing, err := GetVisualSegmentsFromRedis(ctx, videoID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Timestamp < frames[j].Timestamp })
+
+	promptVersion := prompts.ResolveVersion("")
+	var added []VisualSegment
+	var failed []FrameFailure
+	seen := append([]VisualSegment{}, existing...)
+	for _, f := range frames {
+		if ctx.Err() != nil {
+			break
+		}
+		data, err := decodeFrame(f.Frame)
+		var hash uint64
+		if err == nil {
+			hash, err = frameHash(data)
+		}
+		if err != nil {
+			failed = append(failed, FrameFailure{Timestamp: f.Timestamp, Error: err.Error()})
+			continue
+		}
+
+		// Skip frames that match the slide already on screen at this point
+		if prev := segmentBefore(seen, f.Timestamp); prev != nil && hammingDistance(prev.Hash, hash) <= frameDuplicateThreshold {
+			continue
+		}
+
+		segment, err := ExtractFrameContent(ctx, data, promptVersion)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to extract frame", "video_id", videoID, "timestamp", f.Timestamp, "error", err)
+			failed = append(failed, FrameFailure{Timestamp: f.Timestamp, Error: "failed to extract on-screen content"})
+			continue
+		}
+		segment.Timestamp = f.Timestamp
+		segment.Hash = hash
+		segment.PromptVersion = promptVersion
+		added = append(added, segment)
+		seen = append(seen, segment)
+	}
+
+	slog.InfoContext(ctx, "Ingested frames", "video_id", videoID, "frames", len(frames), "distinct", len(added), "failed", len(failed))
+	if len(added) > 0 {
+		// Keep what was extracted even if the request was cancelled meanwhile
+		if err := mergeVisualSegments(context.WithoutCancel(ctx), videoID, added); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return added, failed, nil
+}
+
+// mergeVisualSegments adds segments to the stored visual transcript. Batches of the same video can
+// be ingested concurrently and take minutes, so the merge is retried if another batch was stored
+// in between rather than overwriting it. A new segment replaces a stored one with the same timestamp.
+func mergeVisualSegments(ctx context.Context, videoID string, added []VisualSegment) error {
+	key := visualTranscriptKey(videoID)
+	for attempt := 0; attempt < visualTranscriptMergeAttempts; attempt++ {
+		err := RedisClient.Watch(ctx, func(tx *redis.Tx) error {
+			current, err := GetVisualSegmentsFromRedis(ctx, videoID)
+			if err != nil {
+				return err
+			}
+
+			replaced := make(map[int]bool, len(added))
+			for _, segment := range added {
+				replaced[segment.Timestamp] = true
+			}
+			merged := append([]VisualSegment{}, added...)
+			for _, segment := range current {
+				if !replaced[segment.Timestamp] {
+					merged = append(merged, segment)
+				}
+			}
+			sort.Slice(merged, func(i, j int) bool { return merged[i].Timestamp < merged[j].Timestamp })
+
+			data, err := json.Marshal(merged)
+			if err != nil {
+				return fmt.Errorf("failed to marshal visual transcript: %v", err)
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 168*time.Hour)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			if err != nil {
+				return fmt.Errorf("failed to store visual transcript in Redis: %v", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to store visual transcript in Redis: too many concurrent updates")
+}
+
+// CheckFrame checks that a frame is a PNG, JPEG or GIF image small enough to decode, from its
+// header only
+func CheckFrame(data []byte) error {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if http.DetectContentType(data) == "image/webp" {
+			return fmt.Errorf("%w: WebP frames are not supported, send PNG, JPEG or GIF", ErrInvalidFrame)
+		}
+		return fmt.Errorf("%w: not a PNG, JPEG or GIF image", ErrInvalidFrame)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return fmt.Errorf("%w: %s image is empty", ErrInvalidFrame, format)
+	}
+	if int64(config.Width)*int64(config.Height) > maxFramePixels {
+		return fmt.Errorf("%w: %dx%d image exceeds %d pixels", ErrInvalidFrame, config.Width, config.Height, maxFramePixels)
+	}
+	return nil
+}
+
+// segmentBefore returns the latest segment starting at or before timestamp
+func segmentBefore(segments []VisualSegment, timestamp int) *VisualSegment {
+	var prev *VisualSegment
+	for i := range segments {
+		if segments[i].Timestamp <= timestamp && (prev == nil || segments[i].Timestamp >= prev.Timestamp) {
+			prev = &segments[i]
+		}
+	}
+	return prev
+}
+
+// frameHash computes an 8x8 average hash so near-identical frames hash to nearby values
+func frameHash(data []byte) (uint64, error) {
+	if err := CheckFrame(data); err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return 0, fmt.Errorf("image is empty")
+	}
+
+	var cells [64]uint64
+	var counts [64]uint64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			cell := ((y-b.Min.Y)*8/b.Dy())*8 + (x-b.Min.X)*8/b.Dx()
+			cells[cell] += uint64(299*r+587*g+114*bl) / 1000
+			counts[cell]++
+		}
+	}
+
+	var total uint64
+	for i := range cells {
+		if counts[i] > 0 {
+			cells[i] /= counts[i]
+		}
+		total += cells[i]
+	}
+	mean := total / 64
+
+	var hash uint64
+	for i, v := range cells {
+		if v > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash, nil
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// ExtractFrameContent sends a frame to the vision model and returns the on-screen text and a short description
+func ExtractFrameContent(ctx context.Context, frame []byte, promptVersion string) (VisualSegment, error) {
+	systemPrompt, err := prompts.Render(promptVersion, prompts.FrameExtractionSystem, nil)
+	if err != nil {
+		return VisualSegment{}, err
+	}
+	prompt, err := prompts.Render(promptVersion, prompts.FrameExtraction, nil)
+	if err != nil {
+		return VisualSegment{}, err
+	}
+
+	dataURL := fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(frame), base64.StdEncoding.EncodeToString(frame))
+	requestBody := map[string]interface{}{
+		"model": "gpt-4o-mini",
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": systemPrompt},
+			{"role": "user", "content": []map[string]interface{}{
+				{"type": "text", "text": prompt},
+				{"type": "image_url", "image_url": map[string]string{"url": dataURL}},
+			}},
+		},
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name": "frame_extraction",
+				"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"text":        map[string]interface{}{"type": "string"},
+						"description": map[string]interface{}{"type": "string"},
+					},
+					"required":             []string{"text", "description"},
+					"additionalProperties": false,
+				},
+				"strict": true,
+			},
+		},
+		"temperature": 0.2,
+	}
+
+	content, err := chatCompletionContent(ctx, requestBody)
+	if err != nil {
+		return VisualSegment{}, err
+	}
+
+	var segment VisualSegment
+	if err := json.Unmarshal([]byte(content), &segment); err != nil {
+		return VisualSegment{}, fmt.Errorf("failed to parse frame extraction: %v", err)
+	}
+	return segment, nil
+}
+
+// GetVisualSegmentsFromRedis returns the stored visual transcript segments for a video
+func GetVisualSegmentsFromRedis(ctx context.Context, videoID string) ([]VisualSegment, error) {
+	val, err := RedisClient.Get(ctx, visualTranscriptKey(videoID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving visual transcript from Redis: %v", err)
+	}
+
+	var segments []VisualSegment
+	if err := json.Unmarshal([]byte(val), &segments); err != nil {
+		return nil, fmt.Errorf("failed to decode visual transcript: %v", err)
+	}
+	return segments, nil
+}
+
+// GetVisualTranscriptFromRedis renders the visual transcript in the same "seconds: text" form as the spoken transcript
+func GetVisualTranscriptFromRedis(ctx context.Context, videoID string) (string, error) {
+	segments, err := GetVisualSegmentsFromRedis(ctx, videoID)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, s := range segments {
+		line := strings.Join(strings.Fields(s.Text), " ")
+		if s.Description != "" {
+			line = strings.TrimSpace(line + " (" + s.Description + ")")
+		}
+		fmt.Fprintf(&sb, "%d: %s\n", s.Timestamp, line)
+	}
+	return sb.String(), nil
+}
+
+// CombineTranscripts appends the visual transcript to the spoken one so prompts can use both
+func CombineTranscripts(transcript, visualTranscript string) string {
+	if visualTranscript == "" {
+		return transcript
+	}
+	return fmt.Sprintf("%s\n\nOn-screen content (slides, code and visuals shown in the video):\n%s", transcript, visualTranscript)
+}